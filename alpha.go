@@ -0,0 +1,87 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"strconv"
+	"strings"
+)
+
+// alphaMode decides what shows through transparent pixels: "terminal" leaves
+// the terminal's own background, "blend" mixes them with alphaBackground and
+// "checker" with a grey checkerboard
+var alphaMode = "terminal"
+
+var alphaModes = []string{"terminal", "blend", "checker"}
+
+var alphaBackground = color.RGBA{0, 0, 0, 0xFF}
+
+var checkerColors = []color.RGBA{{0x99, 0x99, 0x99, 0xFF}, {0x66, 0x66, 0x66, 0xFF}}
+
+func validAlphaMode(mode string) bool {
+	for _, m := range alphaModes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// parseHexColor reads colours written as #rrggbb or rrggbb
+func parseHexColor(s string) (color.RGBA, bool) {
+	s = strings.TrimPrefix(s, "#")
+	if len(s) != 6 {
+		return color.RGBA{}, false
+	}
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return color.RGBA{}, false
+	}
+	return color.RGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 0xFF}, true
+}
+
+// flattenAlpha resolves the transparency of img in place. Blending leaves
+// every pixel opaque, for the terminal background pixels end up either fully
+// transparent or opaque. checker is the size of a checkerboard square in
+// pixels.
+func flattenAlpha(img *image.RGBA, checker int) {
+	if img.Opaque() {
+		return
+	}
+	if checker < 1 {
+		checker = 1
+	}
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			off := img.PixOffset(x, y)
+			a := int(img.Pix[off+3])
+			if a == 0xFF {
+				continue
+			}
+
+			if alphaMode == "terminal" {
+				if a < 0x80 {
+					img.Pix[off], img.Pix[off+1], img.Pix[off+2], img.Pix[off+3] = 0, 0, 0, 0
+					continue
+				}
+				// the colour is kept, undo the premultiplication
+				for c := 0; c < 3; c++ {
+					img.Pix[off+c] = clampByte(int(img.Pix[off+c]) * 0xFF / a)
+				}
+				img.Pix[off+3] = 0xFF
+				continue
+			}
+
+			bg := alphaBackground
+			if alphaMode == "checker" {
+				bg = checkerColors[(x/checker+y/checker)%2]
+			}
+			// pixels are premultiplied, so only the background needs scaling
+			img.Pix[off] = clampByte(int(img.Pix[off]) + int(bg.R)*(0xFF-a)/0xFF)
+			img.Pix[off+1] = clampByte(int(img.Pix[off+1]) + int(bg.G)*(0xFF-a)/0xFF)
+			img.Pix[off+2] = clampByte(int(img.Pix[off+2]) + int(bg.B)*(0xFF-a)/0xFF)
+			img.Pix[off+3] = 0xFF
+		}
+	}
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"os"
+	"time"
+)
+
+// disposal says what happens to the area of a frame before the next one is
+// drawn, the values are those of GIF
+const (
+	disposeNone       = gif.DisposalNone
+	disposeBackground = gif.DisposalBackground
+	disposePrevious   = gif.DisposalPrevious
+)
+
+// animFrame is one frame of an animation as it is stored in the file, often
+// only the part of the picture that changed
+type animFrame struct {
+	Image image.Image
+	// Bounds is where Image goes on the canvas
+	Bounds image.Rectangle
+	// Over draws the frame over the canvas, otherwise it replaces its area
+	Over    bool
+	Dispose byte
+	Delay   time.Duration
+}
+
+// animation is an animated GIF or PNG. Frames are composited onto the ones
+// before them when they are shown, the way a browser does it.
+type animation struct {
+	Bounds image.Rectangle
+	Frames []animFrame
+	// Plays is how often the animation runs, 0 is forever
+	Plays int
+
+	canvas *image.RGBA
+	shown  int
+	saved  []byte
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// decodeAnimation decodes data if it is a GIF or PNG with more than one frame
+func decodeAnimation(data []byte) (*animation, error) {
+	var anim *animation
+	var err error
+	switch {
+	case bytes.HasPrefix(data, []byte("GIF87a")), bytes.HasPrefix(data, []byte("GIF89a")):
+		anim, err = decodeGIF(data)
+	case bytes.HasPrefix(data, pngSignature):
+		anim, err = decodeAPNG(data)
+	default:
+		return nil, errors.New("not a GIF or PNG")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(anim.Frames) < 2 {
+		return nil, errors.New("not animated")
+	}
+	anim.canvas = image.NewRGBA(anim.Bounds)
+	anim.shown = -1
+	return anim, nil
+}
+
+// add appends a frame
+func (a *animation) add(f animFrame) {
+	// browsers slow down frames that ask for 10ms or less, many GIFs rely on it
+	if f.Delay <= 10*time.Millisecond {
+		f.Delay = 100 * time.Millisecond
+	}
+	a.Frames = append(a.Frames, f)
+}
+
+// Duration is how long one run of the animation takes
+func (a *animation) Duration() time.Duration {
+	var total time.Duration
+	for _, f := range a.Frames {
+		total += f.Delay
+	}
+	return total
+}
+
+// Frame returns the picture shown at frame n, counted from 0. Going forward
+// only draws the frames in between, going back starts over from the first.
+func (a *animation) Frame(n int) *image.RGBA {
+	if n < a.shown {
+		draw.Draw(a.canvas, a.Bounds, image.Transparent, image.Point{}, draw.Src)
+		a.shown = -1
+	}
+	for a.shown < n {
+		if a.shown >= 0 {
+			last := a.Frames[a.shown]
+			switch last.Dispose {
+			case disposeBackground:
+				// the background colour is left out, browsers show the page through it
+				draw.Draw(a.canvas, last.Bounds, image.Transparent, image.Point{}, draw.Src)
+			case disposePrevious:
+				copy(a.canvas.Pix, a.saved)
+			}
+		}
+		a.shown++
+		f := a.Frames[a.shown]
+		if f.Dispose == disposePrevious {
+			a.saved = append(a.saved[:0], a.canvas.Pix...)
+		}
+		op := draw.Src
+		if f.Over {
+			op = draw.Over
+		}
+		draw.Draw(a.canvas, f.Bounds, f.Image, f.Image.Bounds().Min, op)
+	}
+	// filters change the picture they are given, the canvas has to stay as it is
+	out := image.NewRGBA(a.Bounds)
+	copy(out.Pix, a.canvas.Pix)
+	return out
+}
+
+// Source hands the frames to playHeadless, once
+func (a *animation) Source() frameSource {
+	return func(n int) (image.Image, time.Duration) {
+		if n < 1 || n > len(a.Frames) {
+			return nil, 0
+		}
+		return a.Frame(n - 1), a.Frames[n-1].Delay
+	}
+}
+
+func decodeGIF(data []byte) (*animation, error) {
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	anim := &animation{Bounds: image.Rect(0, 0, g.Config.Width, g.Config.Height)}
+	switch {
+	case g.LoopCount == 0:
+		anim.Plays = 0
+	case g.LoopCount < 0:
+		anim.Plays = 1
+	default:
+		// the loop count doesn't count the first run
+		anim.Plays = g.LoopCount + 1
+	}
+	for n, frame := range g.Image {
+		anim.Bounds = anim.Bounds.Union(frame.Bounds())
+		f := animFrame{
+			Image:  frame,
+			Bounds: frame.Bounds(),
+			// the transparent index has no alpha, Over keeps what is underneath
+			Over:  true,
+			Delay: time.Duration(g.Delay[n]) * 10 * time.Millisecond,
+		}
+		if n < len(g.Disposal) {
+			f.Dispose = g.Disposal[n]
+		}
+		anim.add(f)
+	}
+	return anim, nil
+}
+
+type pngChunk struct {
+	Type string
+	Data []byte
+}
+
+// readPNGChunks splits a PNG file into its chunks, without checking the CRCs
+func readPNGChunks(data []byte) ([]pngChunk, error) {
+	if !bytes.HasPrefix(data, pngSignature) {
+		return nil, errors.New("not a PNG")
+	}
+	var chunks []pngChunk
+	for data = data[len(pngSignature):]; len(data) >= 12; {
+		length := uint64(binary.BigEndian.Uint32(data))
+		if length+12 > uint64(len(data)) {
+			return nil, errors.New("truncated PNG chunk")
+		}
+		chunks = append(chunks, pngChunk{string(data[4:8]), data[8 : 8+length]})
+		data = data[12+length:]
+	}
+	return chunks, nil
+}
+
+func writePNGChunk(buf *bytes.Buffer, kind string, data []byte) {
+	binary.Write(buf, binary.BigEndian, uint32(len(data)))
+	buf.WriteString(kind)
+	buf.Write(data)
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(kind))
+	crc.Write(data)
+	binary.Write(buf, binary.BigEndian, crc.Sum32())
+}
+
+// apngFrame is an fcTL chunk and the image data that follows it
+type apngFrame struct {
+	Width, Height  uint32
+	X, Y           uint32
+	DelayNum       uint16
+	DelayDen       uint16
+	Dispose, Blend byte
+	Data           [][]byte
+}
+
+// decodeAPNG reads the frames of an animated PNG. Go's image/png only
+// reads the default image, so every frame is turned back into a PNG of its
+// own with the header of the file and decoded on its own.
+func decodeAPNG(data []byte) (*animation, error) {
+	chunks, err := readPNGChunks(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var ihdr []byte
+	var shared []pngChunk
+	var frames []*apngFrame
+	var current *apngFrame
+	anim := &animation{}
+	animated, seenData := false, false
+	for _, chunk := range chunks {
+		switch chunk.Type {
+		case "IHDR":
+			ihdr = chunk.Data
+		case "acTL":
+			if len(chunk.Data) < 8 {
+				return nil, errors.New("short acTL chunk")
+			}
+			animated = true
+			anim.Plays = int(binary.BigEndian.Uint32(chunk.Data[4:]))
+		case "fcTL":
+			if len(chunk.Data) < 26 {
+				return nil, errors.New("short fcTL chunk")
+			}
+			d := chunk.Data
+			current = &apngFrame{
+				Width:    binary.BigEndian.Uint32(d[4:]),
+				Height:   binary.BigEndian.Uint32(d[8:]),
+				X:        binary.BigEndian.Uint32(d[12:]),
+				Y:        binary.BigEndian.Uint32(d[16:]),
+				DelayNum: binary.BigEndian.Uint16(d[20:]),
+				DelayDen: binary.BigEndian.Uint16(d[22:]),
+				Dispose:  d[24],
+				Blend:    d[25],
+			}
+			frames = append(frames, current)
+		case "IDAT":
+			seenData = true
+			// without an fcTL first the default image isn't part of the animation
+			if current != nil {
+				current.Data = append(current.Data, chunk.Data)
+			}
+		case "fdAT":
+			if current != nil && len(chunk.Data) > 4 {
+				current.Data = append(current.Data, chunk.Data[4:])
+			}
+		case "IEND":
+		default:
+			// palette, transparency, gamma and the like apply to every frame
+			if !seenData {
+				shared = append(shared, chunk)
+			}
+		}
+	}
+	if !animated {
+		return anim, nil
+	}
+	if len(ihdr) < 13 {
+		return nil, errors.New("missing IHDR chunk")
+	}
+
+	anim.Bounds = image.Rect(0, 0, int(binary.BigEndian.Uint32(ihdr)), int(binary.BigEndian.Uint32(ihdr[4:])))
+	for n, frame := range frames {
+		var buf bytes.Buffer
+		buf.Write(pngSignature)
+		header := append([]byte(nil), ihdr...)
+		binary.BigEndian.PutUint32(header, frame.Width)
+		binary.BigEndian.PutUint32(header[4:], frame.Height)
+		writePNGChunk(&buf, "IHDR", header)
+		for _, chunk := range shared {
+			writePNGChunk(&buf, chunk.Type, chunk.Data)
+		}
+		for _, d := range frame.Data {
+			writePNGChunk(&buf, "IDAT", d)
+		}
+		writePNGChunk(&buf, "IEND", nil)
+		img, err := png.Decode(&buf)
+		if err != nil {
+			return nil, fmt.Errorf("frame %d: %w", n+1, err)
+		}
+
+		den := time.Duration(frame.DelayDen)
+		if den == 0 {
+			den = 100
+		}
+		f := animFrame{
+			Image:  img,
+			Bounds: image.Rect(int(frame.X), int(frame.Y), int(frame.X+frame.Width), int(frame.Y+frame.Height)),
+			Over:   frame.Blend == 1,
+			Delay:  time.Duration(frame.DelayNum) * time.Second / den,
+		}
+		// APNG counts its disposal from 0, GIF from 1
+		f.Dispose = frame.Dispose + 1
+		if f.Dispose == disposePrevious && n == 0 {
+			// nothing to go back to, the spec says to clear instead
+			f.Dispose = disposeBackground
+		}
+		anim.add(f)
+	}
+	return anim, nil
+}
+
+// playAnimation plays a GIF or APNG with the player's status lines and keys.
+// Every frame is shown for its own delay and the animation stops on its last
+// frame once it has run as often as the file asks. a and d step one frame
+// back or forward.
+func playAnimation(name string, anim *animation) {
+	total := anim.Duration()
+
+	// n is the frame on screen, redraw shows it again after a key changed how
+	// it looks. They are shared with the keys, so only used with p.mu held.
+	n, plays := 0, 0
+	done, redraw := false, false
+	var p *player
+	p = newPlayer(name, frameKeys, func(key rune) {
+		switch key {
+		case 'q':
+			if outputMode == "kitty" {
+				os.Stdout.Write(kitty.Clear())
+			}
+			p.app.Stop()
+		case ' ':
+			paused = !paused
+			if !paused && done {
+				n, plays, done = 0, 0, false
+			}
+		case 'a':
+			n = (n + len(anim.Frames) - 1) % len(anim.Frames)
+		case 'd':
+			n = (n + 1) % len(anim.Frames)
+		}
+		redraw = true
+	})
+
+	go func() {
+		for {
+			p.mu.Lock()
+			redraw = false
+			shown := n
+			p.mu.Unlock()
+			// the delay counts from here, drawing the frame is part of it
+			start := time.Now()
+			var at time.Duration
+			for _, f := range anim.Frames[:shown] {
+				at += f.Delay
+			}
+			position := fmt.Sprintf("%s/%s  frame %d/%d", secondsToMinutes(int(at/time.Second)),
+				secondsToMinutes(int((total+time.Second-1)/time.Second)), shown+1, len(anim.Frames))
+			p.show(anim.Frame(shown), nil, position)
+
+			for {
+				p.mu.Lock()
+				wait := (paused || time.Since(start) < anim.Frames[shown].Delay) && !redraw && !resized
+				p.mu.Unlock()
+				if !wait {
+					break
+				}
+				time.Sleep(time.Millisecond)
+			}
+			p.mu.Lock()
+			switch {
+			case redraw || resized || n != shown:
+			case n+1 < len(anim.Frames):
+				n++
+			default:
+				plays++
+				if anim.Plays > 0 && plays >= anim.Plays {
+					// the last frame stays up, space starts it again
+					done, paused = true, true
+				} else {
+					n = 0
+				}
+			}
+			p.mu.Unlock()
+		}
+	}()
+
+	if err := p.run(); err != nil {
+		panic(err)
+	}
+}
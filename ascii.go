@@ -0,0 +1,140 @@
+package main
+
+import (
+	"image"
+	"math"
+)
+
+// asciiRamp lists the glyphs of the ascii mode from darkest to brightest
+var asciiRamp = []rune(" .:-=+*#%@")
+
+// asciiColor draws the ramp glyphs in the colour of the pixels underneath,
+// without it the output is plain text
+var asciiColor = true
+
+// asciiEdges swaps the ramp glyph for / \ | - where the picture has an edge
+var asciiEdges bool
+
+// edgeThreshold is the smallest Sobel magnitude, in luminance steps, that
+// counts as an edge
+const edgeThreshold = 384
+
+// luminance is the brightness of an sRGB colour, Rec. 709 weights
+func luminance(r, g, b uint8) int {
+	return (2126*int(r) + 7152*int(g) + 722*int(b)) / 10000
+}
+
+// convertImageToASCII picks a ramp glyph for every cell by its brightness.
+// Cells are sampled like the half mode, one pixel wide and two high.
+func convertImageToASCII(img image.Image, skip int, mode cellMode) *cellFrame {
+	bounds := img.Bounds()
+	cols := (bounds.Dx() + mode.Width*skip - 1) / (mode.Width * skip)
+	rows := (bounds.Dy() + mode.Height*skip - 1) / (mode.Height * skip)
+	frame := newCellFrame(cols, rows)
+
+	// first the brightness of every cell, the edges need their neighbours
+	lum := make([]int, cols*rows)
+	forEachBand(rows, func(_ int, band rowBand) {
+		for row := band.Lo; row < band.Hi; row++ {
+			y := bounds.Min.Y + row*mode.Height*skip
+			for col := 0; col < cols; col++ {
+				x := bounds.Min.X + col*mode.Width*skip
+				var r, g, b, n int
+				for dy := 0; dy < mode.Height; dy++ {
+					c := pixelAt(img, x, y+dy*skip)
+					if opaque(c.A) == 0 {
+						continue
+					}
+					r += int(c.R)
+					g += int(c.G)
+					b += int(c.B)
+					n++
+				}
+				c := frame.At(col, row)
+				if n == 0 {
+					// nothing to see, the terminal background shows
+					c.Glyph = " "
+					lum[row*cols+col] = -1
+					continue
+				}
+				if asciiColor {
+					c.Fg.R, c.Fg.G, c.Fg.B, c.Fg.A = uint8(r/n), uint8(g/n), uint8(b/n), 0xFF
+				}
+				lum[row*cols+col] = luminance(uint8(r/n), uint8(g/n), uint8(b/n))
+			}
+		}
+	})
+
+	forEachBand(rows, func(_ int, band rowBand) {
+		for row := band.Lo; row < band.Hi; row++ {
+			for col := 0; col < cols; col++ {
+				l := lum[row*cols+col]
+				if l < 0 {
+					continue
+				}
+				c := frame.At(col, row)
+				if asciiEdges {
+					if glyph, ok := edgeGlyph(lum, cols, rows, col, row); ok {
+						c.Glyph = glyph
+						continue
+					}
+				}
+				c.Glyph = string(asciiRamp[l*(len(asciiRamp)-1)/255])
+			}
+		}
+	})
+	return frame
+}
+
+// edgeGlyph runs a Sobel filter over the cell brightness around col, row and
+// returns the line glyph running along the edge, if there is one
+func edgeGlyph(lum []int, cols, rows, col, row int) (string, bool) {
+	at := func(dx, dy int) int {
+		x, y := col+dx, row+dy
+		if x < 0 {
+			x = 0
+		}
+		if x >= cols {
+			x = cols - 1
+		}
+		if y < 0 {
+			y = 0
+		}
+		if y >= rows {
+			y = rows - 1
+		}
+		if l := lum[y*cols+x]; l >= 0 {
+			return l
+		}
+		return 0
+	}
+	gx := at(1, -1) + 2*at(1, 0) + at(1, 1) - at(-1, -1) - 2*at(-1, 0) - at(-1, 1)
+	gy := at(-1, 1) + 2*at(0, 1) + at(1, 1) - at(-1, -1) - 2*at(0, -1) - at(1, -1)
+	// cells are twice as tall as they are wide, so are the steps between rows
+	gy /= 2
+	if abs(gx)+abs(gy) < edgeThreshold {
+		return "", false
+	}
+
+	// the edge runs across the gradient
+	angle := math.Atan2(float64(gy), float64(gx)) * 180 / math.Pi
+	if angle < 0 {
+		angle += 180
+	}
+	switch {
+	case angle < 22.5 || angle >= 157.5:
+		return "|", true
+	case angle < 67.5:
+		return "/", true
+	case angle < 112.5:
+		return "-", true
+	}
+	return "\\", true
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"image"
+)
+
+// cvdMatrices simulate full dichromacy in linear RGB, from Machado, Oliveira
+// and Fernandes (2009) at severity 1
+var cvdMatrices = map[string][3][3]float32{
+	"protanopia": {
+		{0.152286, 1.052583, -0.204868},
+		{0.114503, 0.786281, 0.099216},
+		{-0.003882, -0.048116, 1.051998},
+	},
+	"deuteranopia": {
+		{0.367322, 0.860646, -0.227968},
+		{0.280085, 0.672501, 0.047413},
+		{-0.011820, 0.042940, 0.968881},
+	},
+	"tritanopia": {
+		{1.255528, -0.076749, -0.178779},
+		{-0.078411, 0.930809, 0.147602},
+		{0.004733, 0.691367, 0.303900},
+	},
+}
+
+var cvdTypes = []string{"protanopia", "deuteranopia", "tritanopia"}
+
+// cvdFilter shows a frame the way a viewer with a colour vision deficiency
+// sees it, or with daltonize set shifts the colours they can't tell apart
+// into ones they can
+type cvdFilter struct {
+	kind      string
+	daltonize bool
+}
+
+func (f cvdFilter) Name() string {
+	if f.daltonize {
+		return "daltonize=" + f.kind
+	}
+	return "cvd=" + f.kind
+}
+
+func (f cvdFilter) Apply(img *image.RGBA) {
+	m := cvdMatrices[f.kind]
+	mapPixels(img, func(r, g, b int) (int, int, int) {
+		c := [3]float32{toLinear[r], toLinear[g], toLinear[b]}
+		var sim [3]float32
+		for n := range sim {
+			sim[n] = m[n][0]*c[0] + m[n][1]*c[1] + m[n][2]*c[2]
+		}
+		if f.daltonize {
+			// the colour lost to the deficiency is moved to the channels that
+			// are still seen
+			er, eg, eb := c[0]-sim[0], c[1]-sim[1], c[2]-sim[2]
+			sim = [3]float32{c[0], c[1] + 0.7*er + eg, c[2] + 0.7*er + eb}
+		}
+		return int(linearToByte(sim[0])), int(linearToByte(sim[1])), int(linearToByte(sim[2]))
+	})
+}
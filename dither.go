@@ -0,0 +1,131 @@
+package main
+
+import (
+	"image"
+	"image/color"
+)
+
+// ditherMode is one of "none", "floyd" (Floyd–Steinberg error diffusion) or
+// "ordered" (4x4 Bayer matrix). Dithering only happens below truecolor.
+var ditherMode = "none"
+
+var ditherModes = []string{"none", "floyd", "ordered"}
+
+var bayer4 = [4][4]int{
+	{0, 8, 2, 10},
+	{12, 4, 14, 6},
+	{3, 11, 1, 9},
+	{15, 7, 13, 5},
+}
+
+func validDitherMode(mode string) bool {
+	for _, m := range ditherModes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+func clampByte(v int) uint8 {
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return uint8(v)
+}
+
+// downsample point samples every skip+1 pixel of img into a new image at the
+// resolution it is going to be drawn at
+func downsample(img image.Image, skip int) *image.RGBA {
+	skip += 1
+	bounds := img.Bounds()
+	w := (bounds.Dx() + skip - 1) / skip
+	h := (bounds.Dy() + skip - 1) / skip
+	out := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			out.SetRGBA(x, y, pixelAt(img, bounds.Min.X+x*skip, bounds.Min.Y+y*skip))
+		}
+	}
+	return out
+}
+
+// ditherImage reduces img in place to the palette behind quantize, spread is
+// roughly the distance between two neighbouring palette entries
+func ditherImage(img *image.RGBA, quantize func(color.RGBA) color.RGBA, spread int) {
+	switch ditherMode {
+	case "floyd":
+		floydSteinberg(img, quantize)
+	case "ordered":
+		orderedDither(img, quantize, spread)
+	}
+}
+
+// ditherSpread is the ordered dithering spread for the current colour depth
+func ditherSpread() int {
+	if colorDepth == "16" {
+		return 128
+	}
+	return 48
+}
+
+func floydSteinberg(img *image.RGBA, quantize func(color.RGBA) color.RGBA) {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	// error of the current and the next row, three channels per pixel
+	cur := make([]int, 3*(w+2))
+	next := make([]int, 3*(w+2))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			off := img.PixOffset(x, y)
+			e := 3 * (x + 1)
+			if img.Pix[off+3] == 0 {
+				// transparent pixels are left to the terminal background
+				continue
+			}
+			old := color.RGBA{
+				clampByte(int(img.Pix[off]) + cur[e]/16),
+				clampByte(int(img.Pix[off+1]) + cur[e+1]/16),
+				clampByte(int(img.Pix[off+2]) + cur[e+2]/16),
+				255,
+			}
+			q := quantize(old)
+			img.SetRGBA(x, y, q)
+
+			diff := [3]int{int(old.R) - int(q.R), int(old.G) - int(q.G), int(old.B) - int(q.B)}
+			for c, d := range diff {
+				cur[e+3+c] += d * 7
+				next[e-3+c] += d * 3
+				next[e+c] += d * 5
+				next[e+3+c] += d
+			}
+		}
+		cur, next = next, cur
+		for n := range next {
+			next[n] = 0
+		}
+	}
+}
+
+func orderedDither(img *image.RGBA, quantize func(color.RGBA) color.RGBA, spread int) {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			off := img.PixOffset(x, y)
+			if img.Pix[off+3] == 0 {
+				continue
+			}
+			t := (bayer4[y%4][x%4]*2 - 15) * spread / 32
+			c := color.RGBA{
+				clampByte(int(img.Pix[off]) + t),
+				clampByte(int(img.Pix[off+1]) + t),
+				clampByte(int(img.Pix[off+2]) + t),
+				255,
+			}
+			img.SetRGBA(x, y, quantize(c))
+		}
+	}
+}
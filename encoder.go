@@ -0,0 +1,201 @@
+package main
+
+import (
+	"image/color"
+	"strconv"
+	"sync"
+)
+
+// decimal holds the text of every byte value, so colour codes are appended
+// without going through strconv or fmt for each cell
+var decimal [256][]byte
+
+func init() {
+	for n := range decimal {
+		decimal[n] = []byte(strconv.Itoa(n))
+	}
+}
+
+// SGR colours are kept as a key, the top byte says how the rest is encoded:
+// 0 is the terminal default, 1 is 24-bit rgb, 2 an xterm-256 index and 3 one
+// of the 16 ANSI colours
+const (
+	sgrDefault = 0 << 24
+	sgrRGB     = 1 << 24
+	sgr256     = 2 << 24
+	sgr16      = 3 << 24
+)
+
+func sgrKey(c color.RGBA) uint32 {
+	if c.A == 0 {
+		return sgrDefault
+	}
+	switch colorDepth {
+	case "256":
+		n, _ := nearest256(c)
+		return sgr256 | uint32(n)
+	case "16":
+		n, _ := nearest16(c)
+		return sgr16 | uint32(n)
+	}
+	return sgrRGB | uint32(c.R)<<16 | uint32(c.G)<<8 | uint32(c.B)
+}
+
+// ansiEncoder writes cell frames as ANSI text into a buffer that is reused
+// from frame to frame. Colour codes are only written when the colour differs
+// from the cell before.
+type ansiEncoder struct {
+	buf []byte
+	fg  uint32
+	bg  uint32
+}
+
+var encoderPool = sync.Pool{New: func() interface{} { return new(ansiEncoder) }}
+
+func (e *ansiEncoder) appendSGR(key uint32, background bool) {
+	e.buf = append(e.buf, "\x1b["...)
+	switch key & 0xFF000000 {
+	case sgrDefault:
+		if background {
+			e.buf = append(e.buf, "49"...)
+		} else {
+			e.buf = append(e.buf, "39"...)
+		}
+	case sgrRGB:
+		if background {
+			e.buf = append(e.buf, "48;2;"...)
+		} else {
+			e.buf = append(e.buf, "38;2;"...)
+		}
+		e.buf = append(e.buf, decimal[key>>16&0xFF]...)
+		e.buf = append(e.buf, ';')
+		e.buf = append(e.buf, decimal[key>>8&0xFF]...)
+		e.buf = append(e.buf, ';')
+		e.buf = append(e.buf, decimal[key&0xFF]...)
+	case sgr256:
+		if background {
+			e.buf = append(e.buf, "48;5;"...)
+		} else {
+			e.buf = append(e.buf, "38;5;"...)
+		}
+		e.buf = append(e.buf, decimal[key&0xFF]...)
+	case sgr16:
+		n := key & 0xFF
+		code := 30 + n
+		if n > 7 {
+			code = 90 + n - 8
+		}
+		if background {
+			code += 10
+		}
+		e.buf = append(e.buf, decimal[code]...)
+	}
+	e.buf = append(e.buf, 'm')
+}
+
+// resetState is called after the colours were reset to the terminal default
+func (e *ansiEncoder) resetState() {
+	e.fg = sgrDefault
+	e.bg = sgrDefault
+}
+
+func (e *ansiEncoder) appendCell(c *cell) {
+	fg, bg := sgrKey(c.Fg), sgrKey(c.Bg)
+	glyph := c.Glyph
+	if fg == bg && bg != sgrDefault {
+		// both halves look the same, a space only needs the background
+		glyph = " "
+		fg = e.fg
+	}
+	if bg != e.bg {
+		e.appendSGR(bg, true)
+		e.bg = bg
+	}
+	if fg != e.fg {
+		e.appendSGR(fg, false)
+		e.fg = fg
+	}
+	e.buf = append(e.buf, glyph...)
+}
+
+// Encode draws the whole frame, one line of text per row. The returned slice
+// is only valid until the next call. Bands of rows are encoded in parallel,
+// every row starts from reset colours so the joined text is the same as
+// encoding it in one go. A frame without any colour is plain text.
+func (e *ansiEncoder) Encode(f *cellFrame) []byte {
+	e.buf = e.buf[:0]
+	if f.Colored() {
+		e.buf = append(e.buf, "\x1b[0m"...)
+	}
+	if renderWorkers <= 1 {
+		for y := 0; y < f.Height; y++ {
+			e.appendRow(f, y)
+		}
+		return e.buf
+	}
+
+	parts := make([]*ansiEncoder, len(rowBands(f.Height)))
+	forEachBand(f.Height, func(index int, band rowBand) {
+		part := encoderPool.Get().(*ansiEncoder)
+		part.buf = part.buf[:0]
+		for y := band.Lo; y < band.Hi; y++ {
+			part.appendRow(f, y)
+		}
+		parts[index] = part
+	})
+	for _, part := range parts {
+		e.buf = append(e.buf, part.buf...)
+		encoderPool.Put(part)
+	}
+	return e.buf
+}
+
+func (e *ansiEncoder) appendRow(f *cellFrame, y int) {
+	e.resetState()
+	for x := 0; x < f.Width; x++ {
+		e.appendCell(f.At(x, y))
+	}
+	if e.fg != sgrDefault || e.bg != sgrDefault {
+		e.buf = append(e.buf, "\x1b[0m"...)
+	}
+	e.buf = append(e.buf, '\n')
+}
+
+// EncodeDiff draws only the cells that differ from prev, see
+// cellFrame.DiffANSI. The returned slice is only valid until the next call.
+func (e *ansiEncoder) EncodeDiff(f, prev *cellFrame, row, col int) []byte {
+	if prev == nil || prev.Width != f.Width || prev.Height != f.Height {
+		prev = nil
+	}
+	e.buf = e.buf[:0]
+	e.resetState()
+	dirty := false
+	for y := 0; y < f.Height; y++ {
+		moved := false
+		for x := 0; x < f.Width; x++ {
+			c := f.At(x, y)
+			if prev != nil && *c == *prev.At(x, y) {
+				moved = false
+				continue
+			}
+			if !moved {
+				e.buf = append(e.buf, "\x1b["...)
+				e.buf = strconv.AppendInt(e.buf, int64(row+y), 10)
+				e.buf = append(e.buf, ';')
+				e.buf = strconv.AppendInt(e.buf, int64(col+x), 10)
+				e.buf = append(e.buf, 'H')
+				moved = true
+			}
+			if !dirty {
+				// the terminal may have any colour set when we start
+				e.buf = append(e.buf, "\x1b[0m"...)
+				dirty = true
+			}
+			e.appendCell(c)
+		}
+	}
+	if dirty {
+		e.buf = append(e.buf, "\x1b[0m"...)
+	}
+	return e.buf
+}
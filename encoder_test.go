@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+)
+
+// sprintfEncode is how frames were encoded before ansiEncoder: every row was
+// a string grown with += cell by cell, both colours of every cell went through
+// fmt.Sprintf and were written again even when they hadn't changed
+func sprintfEncode(f *cellFrame) string {
+	ansi := "\x1b[0m"
+	for y := 0; y < f.Height; y++ {
+		sequence := ""
+		for x := 0; x < f.Width; x++ {
+			c := f.At(x, y)
+			sequence += fmt.Sprintf("\x1b[48;2;%d;%d;%dm", c.Bg.R, c.Bg.G, c.Bg.B)
+			sequence += fmt.Sprintf("\x1b[38;2;%d;%d;%dm", c.Fg.R, c.Fg.G, c.Fg.B)
+			sequence += c.Glyph
+		}
+		ansi += sequence + "\x1b[0m\n"
+	}
+	return ansi
+}
+
+// BenchmarkEncode1080p encodes a 1920x1080 frame at the default scale and at
+// -scale 1, on one core and on all of them. Run with -benchmem, encoding
+// should not allocate once the buffer has grown.
+func BenchmarkEncode1080p(b *testing.B) {
+	img := testImage(1920, 1080)
+	workers := renderWorkers
+	defer func() { renderWorkers = workers }()
+
+	counts := []int{1}
+	if runtime.NumCPU() > 1 {
+		counts = append(counts, runtime.NumCPU())
+	}
+	for _, scale := range []int{7, 1} {
+		f := convertImageToCells(img, scale, cellModes[0])
+		for _, n := range counts {
+			b.Run(fmt.Sprintf("scale%d/workers%d", scale, n), func(b *testing.B) {
+				renderWorkers = n
+				e := new(ansiEncoder)
+				b.SetBytes(int64(len(e.Encode(f))))
+				b.ReportAllocs()
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					e.Encode(f)
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkEncode1080pSprintf encodes the same frame as BenchmarkEncode1080p
+// at the default scale the old way, to compare against
+func BenchmarkEncode1080pSprintf(b *testing.B) {
+	f := convertImageToCells(testImage(1920, 1080), 7, cellModes[0])
+	b.SetBytes(int64(len(sprintfEncode(f))))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		sprintfEncode(f)
+	}
+}
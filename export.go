@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// exportName is the file -export writes, a .gif is encoded here and other
+// extensions go through ffmpeg
+var exportName string
+
+// exportDelay is how long every exported frame is shown
+var exportDelay = 40 * time.Millisecond
+
+// exportCellW and exportCellH are the size of one terminal cell in pixels
+var exportCellW, exportCellH = 8, 16
+
+// exportFace draws the glyphs that aren't blocks or braille
+var exportFace font.Face = basicfont.Face7x13
+
+// cells with the terminal's own colours are drawn with these
+var exportForeground = color.RGBA{0xCC, 0xCC, 0xCC, 0xFF}
+var exportBackground = color.RGBA{0, 0, 0, 0xFF}
+
+// frameExporter takes rasterized frames, all of the size it was made with
+type frameExporter interface {
+	Frame(img *image.RGBA) error
+	Close() error
+}
+
+var exporter frameExporter
+
+// parseCellSize reads a cell size written as WxH
+func parseCellSize(s string) (int, int, bool) {
+	ws, hs, ok := strings.Cut(s, "x")
+	if !ok {
+		return 0, 0, false
+	}
+	w, err := strconv.Atoi(ws)
+	if err != nil || w < 2 {
+		return 0, 0, false
+	}
+	h, err := strconv.Atoi(hs)
+	if err != nil || h < 2 {
+		return 0, 0, false
+	}
+	return w, h, true
+}
+
+// loadExportFont opens a TrueType or OpenType font sized to fill a cell
+func loadExportFont(name string) (font.Face, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := opentype.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	return opentype.NewFace(f, &opentype.FaceOptions{
+		Size:    float64(exportCellH) * 0.8,
+		DPI:     72,
+		Hinting: font.HintingFull,
+	})
+}
+
+// glyphMask is the cell mode and pixel mask a glyph was picked for
+type glyphMask struct {
+	Mode cellMode
+	Mask int
+}
+
+// glyphMasks finds the blocks, sextants and braille patterns, which are drawn
+// as rectangles and dots instead of with the font. Most fonts don't have them.
+var glyphMasks = map[string]glyphMask{}
+
+func init() {
+	for _, mode := range cellModes {
+		if mode.Name == "ascii" {
+			continue
+		}
+		for mask := 0; mask < 1<<(mode.Width*mode.Height); mask++ {
+			glyph := mode.Glyph(mask)
+			if _, ok := glyphMasks[glyph]; !ok {
+				glyphMasks[glyph] = glyphMask{mode, mask}
+			}
+		}
+	}
+}
+
+// rasterizeFrame draws f the way a terminal would show it
+func rasterizeFrame(f *cellFrame) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, f.Width*exportCellW, f.Height*exportCellH))
+	drawRows := func(_ int, band rowBand) {
+		for y := band.Lo; y < band.Hi; y++ {
+			for x := 0; x < f.Width; x++ {
+				drawCell(img, x*exportCellW, y*exportCellH, f.At(x, y))
+			}
+		}
+	}
+	if _, ok := exportFace.(*basicfont.Face); !ok {
+		// a -font face keeps its rasterizer in itself, it can only draw one
+		// glyph at a time
+		drawRows(0, rowBand{0, f.Height})
+		return img
+	}
+	forEachBand(f.Height, drawRows)
+	return img
+}
+
+func drawCell(img *image.RGBA, x0, y0 int, c *cell) {
+	fg, bg := exportForeground, exportBackground
+	if c.Fg.A != 0 {
+		fg = quantizeColor(c.Fg)
+	}
+	if c.Bg.A != 0 {
+		bg = quantizeColor(c.Bg)
+	}
+	cellRect := image.Rect(x0, y0, x0+exportCellW, y0+exportCellH)
+	draw.Draw(img, cellRect, &image.Uniform{bg}, image.Point{}, draw.Src)
+
+	if gm, ok := glyphMasks[c.Glyph]; ok {
+		mode := gm.Mode
+		for n := 0; n < mode.Width*mode.Height; n++ {
+			if gm.Mask&(1<<n) == 0 {
+				continue
+			}
+			dx, dy := n%mode.Width, n/mode.Width
+			r := image.Rect(x0+dx*exportCellW/mode.Width, y0+dy*exportCellH/mode.Height,
+				x0+(dx+1)*exportCellW/mode.Width, y0+(dy+1)*exportCellH/mode.Height)
+			if mode.Name == "braille" {
+				// dots, not blocks, with a gap around them
+				r = r.Inset(r.Dx() / 4)
+			}
+			draw.Draw(img, r, &image.Uniform{fg}, image.Point{}, draw.Src)
+		}
+		return
+	}
+
+	metrics := exportFace.Metrics()
+	height := (metrics.Ascent + metrics.Descent).Ceil()
+	advance := font.MeasureString(exportFace, c.Glyph).Ceil()
+	d := font.Drawer{
+		Dst:  img.SubImage(cellRect).(*image.RGBA),
+		Src:  &image.Uniform{fg},
+		Face: exportFace,
+		Dot:  fixed.P(x0+(exportCellW-advance)/2, y0+(exportCellH-height)/2+metrics.Ascent.Ceil()),
+	}
+	d.DrawString(c.Glyph)
+}
+
+// exportFrame rasterizes f and adds it to the -export file, which is created
+// with the size of the first frame
+func exportFrame(f *cellFrame) error {
+	img := rasterizeFrame(f)
+	if exporter == nil {
+		if strings.EqualFold(filepath.Ext(exportName), ".gif") {
+			exporter = newGIFExporter(exportName)
+		} else {
+			video, err := newVideoExporter(exportName, img.Rect.Dx(), img.Rect.Dy())
+			if err != nil {
+				return err
+			}
+			exporter = video
+		}
+	}
+	return exporter.Frame(img)
+}
+
+// gifMemoryLimit is how many bytes of frames a GIF export may hold, image/gif
+// can only write the whole animation at once
+const gifMemoryLimit = 512 << 20
+
+// gifExporter keeps the frames in memory and writes the GIF when it is closed
+type gifExporter struct {
+	name    string
+	anim    gif.GIF
+	palette color.Palette
+	index   map[color.RGBA]uint8
+	elapsed time.Duration
+	size    int
+}
+
+func newGIFExporter(name string) *gifExporter {
+	// the xterm palette, cells only ever use a few of its colours
+	palette := make(color.Palette, 256)
+	for n := range palette {
+		palette[n] = paletteColor(n)
+	}
+	return &gifExporter{name: name, palette: palette, index: map[color.RGBA]uint8{}}
+}
+
+func (g *gifExporter) Frame(img *image.RGBA) error {
+	if len(g.anim.Image) > 0 && img.Rect != g.anim.Image[0].Rect {
+		// a resize under -fit, EncodeAll would only fail at the end
+		first := g.anim.Image[0].Rect
+		return fmt.Errorf("frame %d is %dx%d, the GIF is %dx%d", len(g.anim.Image)+1,
+			img.Rect.Dx(), img.Rect.Dy(), first.Dx(), first.Dy())
+	}
+	if g.size += img.Rect.Dx() * img.Rect.Dy(); g.size > gifMemoryLimit {
+		return fmt.Errorf("the GIF is over %d MB after %d frames, export to a video file or stop sooner with -frames",
+			gifMemoryLimit>>20, len(g.anim.Image))
+	}
+	frame := image.NewPaletted(img.Rect, g.palette)
+	for n := 0; n < len(img.Pix); n += 4 {
+		c := color.RGBA{img.Pix[n], img.Pix[n+1], img.Pix[n+2], 0xFF}
+		idx, ok := g.index[c]
+		if !ok {
+			p, _ := nearest256(c)
+			idx = uint8(p)
+			g.index[c] = idx
+		}
+		frame.Pix[n/4] = idx
+	}
+
+	// delays are in 100ths of a second, rounding the running time keeps long
+	// clips from drifting
+	before := g.elapsed / (10 * time.Millisecond)
+	g.elapsed += exportDelay
+	g.anim.Image = append(g.anim.Image, frame)
+	g.anim.Delay = append(g.anim.Delay, int(g.elapsed/(10*time.Millisecond)-before))
+	return nil
+}
+
+func (g *gifExporter) Close() error {
+	file, err := os.Create(g.name)
+	if err != nil {
+		return err
+	}
+	if err := gif.EncodeAll(file, &g.anim); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"image"
+	"strconv"
+	"strings"
+)
+
+// frameFilter changes a frame in place after it has been scaled and before
+// it is turned into cells or pixel graphics
+type frameFilter interface {
+	Name() string
+	Apply(img *image.RGBA)
+}
+
+// filterSlot is one filter of the -vf chain, keys 1 to 9 switch it on and off
+// during playback
+type filterSlot struct {
+	Filter  frameFilter
+	Enabled bool
+}
+
+var filterChain []filterSlot
+
+var filterNames = []string{"grayscale", "sepia", "invert", "edge", "posterize", "pixelate", "crt", "mirror", "cvd", "daltonize"}
+
+// parseFilters reads a -vf chain such as "grayscale,posterize=4,mirror=v" or
+// "cvd=deuteranopia".
+// It returns the first entry it doesn't understand.
+func parseFilters(spec string) ([]filterSlot, string, bool) {
+	var chain []filterSlot
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		f, ok := parseFilter(entry)
+		if !ok {
+			return nil, entry, false
+		}
+		chain = append(chain, filterSlot{Filter: f, Enabled: true})
+	}
+	return chain, "", true
+}
+
+// parseFilter reads one filter, written as name or name=argument
+func parseFilter(entry string) (frameFilter, bool) {
+	name, arg, hasArg := strings.Cut(entry, "=")
+	number := func(def, min int) (int, bool) {
+		if !hasArg {
+			return def, true
+		}
+		n, err := strconv.Atoi(arg)
+		return n, err == nil && n >= min
+	}
+	switch name {
+	case "grayscale":
+		return grayscaleFilter{}, !hasArg
+	case "sepia":
+		return sepiaFilter{}, !hasArg
+	case "invert":
+		return invertFilter{}, !hasArg
+	case "edge":
+		return edgeFilter{}, !hasArg
+	case "posterize":
+		n, ok := number(4, 2)
+		return posterizeFilter{n}, ok
+	case "pixelate":
+		n, ok := number(4, 1)
+		return pixelateFilter{n}, ok
+	case "crt":
+		return crtFilter{}, !hasArg
+	case "cvd", "daltonize":
+		_, ok := cvdMatrices[arg]
+		return cvdFilter{kind: arg, daltonize: name == "daltonize"}, ok
+	case "mirror":
+		if !hasArg || arg == "h" {
+			return mirrorFilter{}, true
+		}
+		return mirrorFilter{vertical: true}, arg == "v"
+	}
+	return nil, false
+}
+
+// filtersActive reports whether any filter of the chain is switched on
+func filtersActive() bool {
+	for _, slot := range filterChain {
+		if slot.Enabled {
+			return true
+		}
+	}
+	return false
+}
+
+// applyFilters runs the enabled filters of the chain over img, in order
+func applyFilters(img *image.RGBA) {
+	for _, slot := range filterChain {
+		if slot.Enabled {
+			slot.Filter.Apply(img)
+		}
+	}
+}
+
+// toggleFilter switches filter n of the chain (counting from 1) on or off.
+// It reports whether the key was for a filter.
+func toggleFilter(key rune) bool {
+	n := int(key - '1')
+	if key < '1' || key > '9' || n >= len(filterChain) {
+		return false
+	}
+	filterChain[n].Enabled = !filterChain[n].Enabled
+	return true
+}
+
+// filterStatus lists the filters of the chain for the status lines, the ones
+// switched off in brackets
+func filterStatus() string {
+	if len(filterChain) == 0 {
+		return ""
+	}
+	names := make([]string, len(filterChain))
+	for n, slot := range filterChain {
+		names[n] = strconv.Itoa(n+1) + ":" + slot.Filter.Name()
+		if !slot.Enabled {
+			names[n] = "(" + names[n] + ")"
+		}
+	}
+	return "  vf " + strings.Join(names, " ")
+}
+
+// mapPixels replaces the colour of every visible pixel of img with what fn
+// returns for it. fn works on straight colours, pixels that are partly
+// transparent are converted from and back to premultiplied ones.
+func mapPixels(img *image.RGBA, fn func(r, g, b int) (int, int, int)) {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	forEachBand(h, func(_ int, band rowBand) {
+		for y := band.Lo; y < band.Hi; y++ {
+			for x := 0; x < w; x++ {
+				off := img.PixOffset(img.Rect.Min.X+x, img.Rect.Min.Y+y)
+				a := int(img.Pix[off+3])
+				if a == 0 {
+					continue
+				}
+				var c [3]int
+				for n := range c {
+					c[n] = int(img.Pix[off+n])
+					if a != 0xFF {
+						c[n] = int(clampByte(c[n] * 0xFF / a))
+					}
+				}
+				c[0], c[1], c[2] = fn(c[0], c[1], c[2])
+				for n := range c {
+					v := clampByte(c[n])
+					if a != 0xFF {
+						v = uint8(int(v) * a / 0xFF)
+					}
+					img.Pix[off+n] = v
+				}
+			}
+		}
+	})
+}
+
+type grayscaleFilter struct{}
+
+func (grayscaleFilter) Name() string { return "grayscale" }
+
+func (grayscaleFilter) Apply(img *image.RGBA) {
+	mapPixels(img, func(r, g, b int) (int, int, int) {
+		l := luminance(uint8(r), uint8(g), uint8(b))
+		return l, l, l
+	})
+}
+
+type sepiaFilter struct{}
+
+func (sepiaFilter) Name() string { return "sepia" }
+
+func (sepiaFilter) Apply(img *image.RGBA) {
+	mapPixels(img, func(r, g, b int) (int, int, int) {
+		return (393*r + 769*g + 189*b) / 1000,
+			(349*r + 686*g + 168*b) / 1000,
+			(272*r + 534*g + 131*b) / 1000
+	})
+}
+
+type invertFilter struct{}
+
+func (invertFilter) Name() string { return "invert" }
+
+func (invertFilter) Apply(img *image.RGBA) {
+	mapPixels(img, func(r, g, b int) (int, int, int) {
+		return 0xFF - r, 0xFF - g, 0xFF - b
+	})
+}
+
+type posterizeFilter struct {
+	levels int
+}
+
+func (f posterizeFilter) Name() string { return "posterize=" + strconv.Itoa(f.levels) }
+
+func (f posterizeFilter) Apply(img *image.RGBA) {
+	steps := f.levels - 1
+	level := func(c int) int {
+		return (c*steps + 0x7F) / 0xFF * 0xFF / steps
+	}
+	mapPixels(img, func(r, g, b int) (int, int, int) {
+		return level(r), level(g), level(b)
+	})
+}
+
+// edgeFilter draws the Sobel gradient of the brightness, white edges on black
+type edgeFilter struct{}
+
+func (edgeFilter) Name() string { return "edge" }
+
+func (edgeFilter) Apply(img *image.RGBA) {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	lum := make([]int, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			off := img.PixOffset(img.Rect.Min.X+x, img.Rect.Min.Y+y)
+			lum[y*w+x] = luminance(img.Pix[off], img.Pix[off+1], img.Pix[off+2])
+		}
+	}
+	at := func(x, y int) int {
+		if x < 0 {
+			x = 0
+		}
+		if x >= w {
+			x = w - 1
+		}
+		if y < 0 {
+			y = 0
+		}
+		if y >= h {
+			y = h - 1
+		}
+		return lum[y*w+x]
+	}
+	forEachBand(h, func(_ int, band rowBand) {
+		for y := band.Lo; y < band.Hi; y++ {
+			for x := 0; x < w; x++ {
+				off := img.PixOffset(img.Rect.Min.X+x, img.Rect.Min.Y+y)
+				if img.Pix[off+3] == 0 {
+					continue
+				}
+				gx := at(x+1, y-1) + 2*at(x+1, y) + at(x+1, y+1) - at(x-1, y-1) - 2*at(x-1, y) - at(x-1, y+1)
+				gy := at(x-1, y+1) + 2*at(x, y+1) + at(x+1, y+1) - at(x-1, y-1) - 2*at(x, y-1) - at(x+1, y-1)
+				v := clampByte((abs(gx) + abs(gy)) / 2)
+				if a := int(img.Pix[off+3]); a != 0xFF {
+					v = uint8(int(v) * a / 0xFF)
+				}
+				img.Pix[off], img.Pix[off+1], img.Pix[off+2] = v, v, v
+			}
+		}
+	})
+}
+
+// pixelateFilter fills every size by size block with its average colour
+type pixelateFilter struct {
+	size int
+}
+
+func (f pixelateFilter) Name() string { return "pixelate=" + strconv.Itoa(f.size) }
+
+func (f pixelateFilter) Apply(img *image.RGBA) {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	blocks := (h + f.size - 1) / f.size
+	forEachBand(blocks, func(_ int, band rowBand) {
+		for by := band.Lo * f.size; by < band.Hi*f.size && by < h; by += f.size {
+			for bx := 0; bx < w; bx += f.size {
+				// premultiplied pixels can simply be averaged
+				var sum [4]int
+				n := 0
+				for y := by; y < by+f.size && y < h; y++ {
+					for x := bx; x < bx+f.size && x < w; x++ {
+						off := img.PixOffset(img.Rect.Min.X+x, img.Rect.Min.Y+y)
+						for c := range sum {
+							sum[c] += int(img.Pix[off+c])
+						}
+						n++
+					}
+				}
+				for y := by; y < by+f.size && y < h; y++ {
+					for x := bx; x < bx+f.size && x < w; x++ {
+						off := img.PixOffset(img.Rect.Min.X+x, img.Rect.Min.Y+y)
+						for c := range sum {
+							img.Pix[off+c] = uint8(sum[c] / n)
+						}
+					}
+				}
+			}
+		}
+	})
+}
+
+// crtFilter darkens every other row like the scanlines of a CRT
+type crtFilter struct{}
+
+func (crtFilter) Name() string { return "crt" }
+
+func (crtFilter) Apply(img *image.RGBA) {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	for y := 1; y < h; y += 2 {
+		for x := 0; x < w; x++ {
+			off := img.PixOffset(img.Rect.Min.X+x, img.Rect.Min.Y+y)
+			for c := 0; c < 3; c++ {
+				img.Pix[off+c] = uint8(int(img.Pix[off+c]) * 5 / 10)
+			}
+		}
+	}
+}
+
+// mirrorFilter flips the frame left to right, or upside down
+type mirrorFilter struct {
+	vertical bool
+}
+
+func (f mirrorFilter) Name() string {
+	if f.vertical {
+		return "mirror=v"
+	}
+	return "mirror"
+}
+
+func (f mirrorFilter) Apply(img *image.RGBA) {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	if f.vertical {
+		row := make([]uint8, 4*w)
+		for y := 0; y < h/2; y++ {
+			top := img.Pix[img.PixOffset(img.Rect.Min.X, img.Rect.Min.Y+y):][:4*w]
+			bottom := img.Pix[img.PixOffset(img.Rect.Min.X, img.Rect.Min.Y+h-1-y):][:4*w]
+			copy(row, top)
+			copy(top, bottom)
+			copy(bottom, row)
+		}
+		return
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w/2; x++ {
+			a := img.PixOffset(img.Rect.Min.X+x, img.Rect.Min.Y+y)
+			b := img.PixOffset(img.Rect.Min.X+w-1-x, img.Rect.Min.Y+y)
+			for c := 0; c < 4; c++ {
+				img.Pix[a+c], img.Pix[b+c] = img.Pix[b+c], img.Pix[a+c]
+			}
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"image"
+	"math"
+	"os"
+
+	"golang.org/x/term"
+)
+
+// fitScreen scales pictures to fill the terminal instead of using -scale
+var fitScreen bool
+
+// cellAspect is the height of a terminal cell divided by its width
+var cellAspect = 2.0
+
+// reservedRows are kept free under the picture, for the shell prompt or the
+// playback status lines
+var reservedRows = 1
+
+// statusLines is the height of the status text under a playing frame
+const statusLines = 4
+
+// screenCols and screenRows hold the size tview last drew at, zero before
+// the player has started
+var screenCols, screenRows int
+
+// resized is set when the terminal changed size during playback, the
+// current frame is then rendered again for the new size
+var resized bool
+
+// terminalSize returns the size of the terminal in cells
+func terminalSize() (int, int) {
+	if screenCols > 0 && screenRows > 0 {
+		return screenCols, screenRows
+	}
+	cols, rows, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil || cols <= 0 || rows <= 0 {
+		return 80, 24
+	}
+	return cols, rows
+}
+
+// fitArea is the part of the terminal a fitted picture may cover
+func fitArea() (int, int) {
+	cols, rows := terminalSize()
+	rows -= reservedRows
+	if rows < 1 {
+		rows = 1
+	}
+	return cols, rows
+}
+
+// fitCells returns how many cells a w by h picture covers when it is scaled
+// to fit into cols by rows cells without changing its aspect ratio
+func fitCells(w, h, cols, rows int) (int, int) {
+	if w <= 0 || h <= 0 {
+		return 1, 1
+	}
+	// measured in cell widths, the picture is cols wide and this tall
+	height := float64(cols) * float64(h) / float64(w) / cellAspect
+	fitCols, fitRows := cols, int(math.Round(height))
+	if fitRows > rows {
+		fitCols = int(math.Round(float64(rows) * cellAspect * float64(w) / float64(h)))
+		fitRows = rows
+	}
+	if fitCols < 1 {
+		fitCols = 1
+	}
+	if fitRows < 1 {
+		fitRows = 1
+	}
+	return fitCols, fitRows
+}
+
+// fitImage scales img to exactly fill the cells fitCells picks for it in the
+// given mode
+func fitImage(img image.Image, mode cellMode, cols, rows int) *image.RGBA {
+	fitCols, fitRows := fitCells(img.Bounds().Dx(), img.Bounds().Dy(), cols, rows)
+	return resize(img, fitCols*mode.Width, fitRows*mode.Height)
+}
+
+// letterbox centres f in a cols by rows frame, the bars around it are left
+// in the terminal's background colour
+func letterbox(f *cellFrame, cols, rows int) *cellFrame {
+	if f.Width >= cols && f.Height >= rows {
+		return f
+	}
+	if cols < f.Width {
+		cols = f.Width
+	}
+	if rows < f.Height {
+		rows = f.Height
+	}
+	out := newCellFrame(cols, rows)
+	for n := range out.Cells {
+		out.Cells[n].Glyph = " "
+	}
+	left := (cols - f.Width) / 2
+	top := (rows - f.Height) / 2
+	for y := 0; y < f.Height; y++ {
+		copy(out.Cells[(top+y)*cols+left:], f.Cells[y*f.Width:(y+1)*f.Width])
+	}
+	return out
+}
+
+// frameDivisor says by how much ExtractImages shrinks the frames of a w by
+// h video. Fitted text output only needs as many pixels as the terminal can
+// show, graphics output gets the full picture.
+func frameDivisor(w, h int) int {
+	if !fitScreen {
+		return 2
+	}
+	if outputMode != "ansi" {
+		return 1
+	}
+	mode := cellModes[0]
+	for _, m := range cellModes {
+		// leave enough pixels for the densest mode, it can be picked while playing
+		if m.Width*m.Height > mode.Width*mode.Height {
+			mode = m
+		}
+	}
+	cols, rows := fitArea()
+	fitCols, fitRows := fitCells(w, h, cols, rows)
+	divisor := w / (fitCols * mode.Width)
+	if d := h / (fitRows * mode.Height); d < divisor {
+		divisor = d
+	}
+	if divisor < 1 {
+		divisor = 1
+	}
+	return divisor
+}
+
+// graphicsCells is the number of cells a w by h picture covers when it is
+// fitted to the terminal by a graphics protocol
+func graphicsCells(w, h int) (int, int) {
+	cols, rows := fitArea()
+	return fitCells(w, h, cols, rows)
+}
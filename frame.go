@@ -0,0 +1,93 @@
+package main
+
+import (
+	"image/color"
+)
+
+// changeThreshold is the largest change of a colour channel that still counts
+// as the same colour when a frame is compared with the one before it
+var changeThreshold = 8
+
+// cell is one character cell of a rendered frame. A background with zero
+// alpha leaves the terminal's own background showing.
+type cell struct {
+	Glyph string
+	Fg    color.RGBA
+	Bg    color.RGBA
+}
+
+// cellFrame is a picture converted to a grid of cells, stored row by row
+type cellFrame struct {
+	Width  int
+	Height int
+	Cells  []cell
+}
+
+func newCellFrame(w, h int) *cellFrame {
+	return &cellFrame{Width: w, Height: h, Cells: make([]cell, w*h)}
+}
+
+func (f *cellFrame) At(x, y int) *cell {
+	return &f.Cells[y*f.Width+x]
+}
+
+// Colored reports whether any cell has a colour other than the terminal's
+func (f *cellFrame) Colored() bool {
+	for n := range f.Cells {
+		if f.Cells[n].Fg.A != 0 || f.Cells[n].Bg.A != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// ANSI draws the whole frame, one line of text per row
+func (f *cellFrame) ANSI() string {
+	e := encoderPool.Get().(*ansiEncoder)
+	ansi := string(e.Encode(f))
+	encoderPool.Put(e)
+	return ansi
+}
+
+func closeColor(a, b color.RGBA, threshold int) bool {
+	if (a.A == 0) != (b.A == 0) {
+		return false
+	}
+	return absDiff(a.R, b.R) <= threshold && absDiff(a.G, b.G) <= threshold && absDiff(a.B, b.B) <= threshold
+}
+
+func absDiff(a, b uint8) int {
+	if a > b {
+		return int(a - b)
+	}
+	return int(b - a)
+}
+
+// Stabilize copies every cell of prev that is within threshold of the cell
+// in f, so colour noise doesn't count as a change. It returns how many cells
+// really changed, every cell if the frames don't have the same size.
+func (f *cellFrame) Stabilize(prev *cellFrame, threshold int) int {
+	if prev == nil || prev.Width != f.Width || prev.Height != f.Height {
+		return len(f.Cells)
+	}
+	changed := 0
+	for n := range f.Cells {
+		c, p := &f.Cells[n], &prev.Cells[n]
+		if c.Glyph == p.Glyph && closeColor(c.Fg, p.Fg, threshold) && closeColor(c.Bg, p.Bg, threshold) {
+			*c = *p
+		} else {
+			changed++
+		}
+	}
+	return changed
+}
+
+// DiffANSI draws only the cells that differ from prev, for writing straight
+// to a terminal where prev is already on screen. Each run of changed cells
+// starts with a cursor move relative to row and col (both 1-based).
+func (f *cellFrame) DiffANSI(prev *cellFrame, row, col int) string {
+	e := encoderPool.Get().(*ansiEncoder)
+	ansi := string(e.EncodeDiff(f, prev, row, col))
+	encoderPool.Put(e)
+	return ansi
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"math"
+	"strconv"
+)
+
+// outputMode selects how frames reach the terminal: "ansi" draws coloured
+// text cells, the others send real pixels through a terminal graphics protocol
+var outputMode = "ansi"
+
+var outputModes = []string{"ansi", "sixel", "kitty", "iterm"}
+
+// graphicsRow is the terminal row graphics are drawn from during playback,
+// the status lines sit above it
+const graphicsRow = 5
+
+func validOutputMode(mode string) bool {
+	for _, m := range outputModes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// graphicsSize picks the pixel size of a frame so that it covers about the
+// same area as the text renderer would at the same scale, assuming cells are
+// 8 pixels wide.
+func graphicsSize(bounds image.Rectangle, skip int) (int, int) {
+	w := bounds.Dx() * 8 / (skip + 1)
+	h := bounds.Dy() * 8 / (skip + 1)
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+	return w, h
+}
+
+// resizeNearest point samples img into a w by h image
+func resizeNearest(img image.Image, w, h int) *image.RGBA {
+	bounds := img.Bounds()
+	out := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		sy := bounds.Min.Y + y*bounds.Dy()/h
+		for x := 0; x < w; x++ {
+			sx := bounds.Min.X + x*bounds.Dx()/w
+			c := pixelAt(img, sx, sy)
+			off := out.PixOffset(x, y)
+			out.Pix[off] = c.R
+			out.Pix[off+1] = c.G
+			out.Pix[off+2] = c.B
+			out.Pix[off+3] = c.A
+		}
+	}
+	return out
+}
+
+// renderGraphics encodes a picture for the selected graphics protocol, and
+// returns how many cells it covers
+func renderGraphics(picture []byte) ([]byte, int, int) {
+	return renderGraphicsImage(openImage(picture), picture)
+}
+
+// renderGraphicsImage is renderGraphics for a decoded picture. picture is the
+// file it was decoded from, kitty and iTerm2 can be sent that as it is, or nil.
+func renderGraphicsImage(img image.Image, picture []byte) ([]byte, int, int) {
+	if skip == 0 {
+		skip = 7
+	}
+	full := img.Bounds()
+	img = zoomCrop(img)
+	var w, h, cols, rows int
+	if fitScreen {
+		cols, rows = graphicsCells(full.Dx(), full.Dy())
+		w, h = cols*8, int(float64(rows*8)*cellAspect)
+	} else {
+		w, h = graphicsSize(full, skip)
+		cols, rows = (w+7)/8, int(math.Ceil(float64(h)/(8*cellAspect)))
+	}
+
+	if outputMode == "sixel" {
+		frame := resize(img, w, h)
+		flattenAlpha(frame, 16)
+		adjustTone(frame)
+		applyFilters(frame)
+		return encodeSixel(frame), cols, rows
+	}
+
+	// kitty and iTerm2 scale the picture into the cells themselves, and blend
+	// transparent pictures with the terminal background unless asked otherwise
+	passthrough := alphaMode == "terminal"
+	if o, ok := img.(interface{ Opaque() bool }); ok && o.Opaque() {
+		passthrough = true
+	}
+	if !passthrough || toneActive() || filtersActive() || zoomLevel > 1 {
+		flat := toRGBA(img)
+		if !passthrough {
+			flattenAlpha(flat, 16)
+		}
+		adjustTone(flat)
+		applyFilters(flat)
+		img = flat
+		// the file itself no longer matches what is drawn
+		passthrough = false
+	}
+	switch outputMode {
+	case "kitty":
+		if passthrough && bytes.HasPrefix(picture, imageMagic[0]) {
+			return kitty.PNG(picture, cols, rows), cols, rows
+		}
+		return kitty.Frame(img, cols, rows), cols, rows
+	case "iterm":
+		if passthrough && isImage(picture) {
+			// the file is sent as it is, the terminal decodes it
+			return encodeITerm(picture, cols, rows), cols, rows
+		}
+		return encodeITermFrame(img, cols, rows), cols, rows
+	}
+	return nil, 0, 0
+}
+
+// graphicsOffset is how far a cols by rows picture is moved right and down to
+// centre it when it is fitted to the terminal
+func graphicsOffset(cols, rows int) (int, int) {
+	if !fitScreen {
+		return 0, 0
+	}
+	areaCols, areaRows := fitArea()
+	left, top := (areaCols-cols)/2, (areaRows-rows)/2
+	if left < 0 {
+		left = 0
+	}
+	if top < 0 {
+		top = 0
+	}
+	return left, top
+}
+
+// placeGraphics wraps an encoded frame so it is drawn at the top left of the
+// playback area without moving the cursor tview keeps track of
+func placeGraphics(seq []byte, cols, rows int) []byte {
+	left, top := graphicsOffset(cols, rows)
+	out := make([]byte, 0, len(seq)+24)
+	out = append(out, "\x1b7\x1b["...)
+	out = append(out, strconv.Itoa(graphicsRow+top)...)
+	out = append(out, ';')
+	out = append(out, strconv.Itoa(1+left)...)
+	out = append(out, 'H')
+	out = append(out, seq...)
+	out = append(out, "\x1b8"...)
+	return out
+}
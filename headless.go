@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"image"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// headless plays videos without tview or sound, set by -headless, by -export
+// or when stdout isn't a terminal
+var headless bool
+
+// maxFrames stops headless playback after that many frames, 0 plays all of
+// them
+var maxFrames int
+
+// frameSource returns the picture of frame n, counted from 1, and how long it
+// is shown. The picture is nil when there is no such frame.
+type frameSource func(n int) (image.Image, time.Duration)
+
+// extractedFrames reads the frames ExtractImages wrote, each shown for delay
+func extractedFrames(delay time.Duration) frameSource {
+	return func(n int) (image.Image, time.Duration) {
+		buf, err := os.ReadFile("frames/" + strconv.Itoa(n) + ".jpg")
+		if err != nil {
+			return nil, 0
+		}
+		return openImage(buf), delay
+	}
+}
+
+// frameTime is how long each of frames is shown in a video that is seconds
+// long
+func frameTime(frames, seconds int) (time.Duration, error) {
+	if frames <= 0 || seconds <= 0 {
+		return 0, errors.New("the frame rate of the video is unknown")
+	}
+	return time.Duration(float64(seconds) * float64(time.Second) / float64(frames)), nil
+}
+
+// playHeadless plays the frames without a terminal UI until they run out or
+// stop receives something. Without -record or -export they are streamed to
+// stdout, see streamFrames. keys is the legend recorded under the help line.
+func playHeadless(frames int, source frameSource, keys string, stop <-chan os.Signal) {
+	if maxFrames > 0 && maxFrames < frames {
+		frames = maxFrames
+	}
+	if recorder == nil && exportName == "" {
+		streamFrames(frames, source, stop)
+		return
+	}
+
+	// frames are rendered as fast as they can be and recorded at their time in
+	// the video instead of the wall clock
+	var last *cellFrame
+	var at time.Duration
+	for n := 1; n <= frames; n++ {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		img, delay := source(n)
+		if img == nil {
+			break
+		}
+		frame := renderImage(img)
+		// colour noise would only make the recording bigger
+		frame.Stabilize(last, changeThreshold)
+		last = frame
+		position := secondsToMinutes(int(at/time.Second)) + "/" + secondsToMinutes(TotalDuration)
+		if recorder != nil {
+			recorder.Frame(at, frame, statusText(position, "FileName: "+recorder.title, keys))
+		}
+		if exportName != "" {
+			exportDelay = delay
+			if err := exportFrame(frame); err != nil {
+				log.Fatal("Unable to export: " + err.Error())
+			}
+		}
+		at += delay
+	}
+}
+
+// streamFrames writes every frame to stdout as long as the source says, each
+// one drawn over the last from the top left corner. A terminal skips frames
+// when it falls behind, a pipe gets all of them.
+func streamFrames(frames int, source frameSource, stop <-chan os.Signal) {
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	drop := term.IsTerminal(int(os.Stdout.Fd()))
+
+	out.WriteString("\x1b[2J")
+	start := time.Now()
+	var due time.Duration
+	for n := 1; n <= frames; n++ {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		img, delay := source(n)
+		if img == nil {
+			break
+		}
+		if wait := due - time.Since(start); wait > 0 {
+			select {
+			case <-stop:
+				return
+			case <-time.After(wait):
+			}
+		} else if drop && -wait > delay && n < frames {
+			due += delay
+			continue
+		}
+		due += delay
+		out.WriteString("\x1b[H")
+		if outputMode != "ansi" {
+			seq, _, _ := renderGraphicsImage(img, nil)
+			out.Write(seq)
+		} else {
+			out.WriteString(renderImage(img).ANSI())
+		}
+		out.Flush()
+	}
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/jpeg"
+	"strconv"
+)
+
+// encodeITerm wraps an encoded image file (anything the terminal can decode)
+// in the iTerm2 inline image sequence, stretched over cols by rows cells
+func encodeITerm(data []byte, cols, rows int) []byte {
+	var out bytes.Buffer
+	out.WriteString("\x1b]1337;File=inline=1;size=")
+	out.WriteString(strconv.Itoa(len(data)))
+	out.WriteString(";width=")
+	out.WriteString(strconv.Itoa(cols))
+	out.WriteString(";height=")
+	out.WriteString(strconv.Itoa(rows))
+	out.WriteString(";preserveAspectRatio=1:")
+	out.WriteString(base64.StdEncoding.EncodeToString(data))
+	out.WriteByte('\a')
+	return out.Bytes()
+}
+
+// encodeITermFrame sends a decoded frame, JPEG keeps the payload small enough
+// for playback
+func encodeITermFrame(img image.Image, cols, rows int) []byte {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 80}); err != nil {
+		return nil
+	}
+	return encodeITerm(buf.Bytes(), cols, rows)
+}
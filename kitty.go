@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"hash/fnv"
+	"image"
+	"image/draw"
+	"strconv"
+)
+
+// kitty only accepts 4096 bytes of base64 per escape
+const kittyChunk = 4096
+
+// kittyRenderer sends frames with the kitty graphics protocol. Two image ids
+// are used in turn, so the new frame is placed before the old one is deleted
+// and the picture never flashes blank. A frame that is identical to the one on
+// screen is not sent again.
+type kittyRenderer struct {
+	id   int
+	last uint64
+	cols int
+	rows int
+}
+
+var kitty kittyRenderer
+
+// Frame transmits img as zlib compressed RGBA and places it over cols by rows
+// cells, the terminal does the scaling so the full resolution is kept
+func (k *kittyRenderer) Frame(img image.Image, cols, rows int) []byte {
+	// kitty wants straight alpha, image.RGBA is premultiplied
+	rgba, ok := img.(*image.NRGBA)
+	if !ok || rgba.Stride != 4*rgba.Rect.Dx() {
+		rgba = image.NewNRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+		draw.Draw(rgba, rgba.Rect, img, img.Bounds().Min, draw.Src)
+	}
+
+	h := fnv.New64a()
+	h.Write(rgba.Pix)
+	sum := h.Sum64()
+	if k.id != 0 && sum == k.last && cols == k.cols && rows == k.rows {
+		return []byte{}
+	}
+	k.last, k.cols, k.rows = sum, cols, rows
+
+	var payload bytes.Buffer
+	zw, _ := zlib.NewWriterLevel(&payload, zlib.BestSpeed)
+	zw.Write(rgba.Pix)
+	zw.Close()
+
+	control := "f=32,o=z,s=" + strconv.Itoa(rgba.Rect.Dx()) + ",v=" + strconv.Itoa(rgba.Rect.Dy())
+	return k.send(control, payload.Bytes(), cols, rows)
+}
+
+// Reset forgets the frame on screen, so the next one is sent and placed again
+func (k *kittyRenderer) Reset() {
+	k.last = 0
+}
+
+// PNG transmits an already encoded PNG file, the terminal decodes it itself
+func (k *kittyRenderer) PNG(data []byte, cols, rows int) []byte {
+	k.last = 0
+	return k.send("f=100", data, cols, rows)
+}
+
+// Clear deletes every image this program placed on the screen
+func (k *kittyRenderer) Clear() []byte {
+	k.id = 0
+	k.last = 0
+	return []byte("\x1b_Ga=d,d=A,q=2\x1b\\")
+}
+
+func (k *kittyRenderer) send(control string, payload []byte, cols, rows int) []byte {
+	old := k.id
+	k.id = 1
+	if old == 1 {
+		k.id = 2
+	}
+
+	var out bytes.Buffer
+	encoded := base64.StdEncoding.EncodeToString(payload)
+	for n := 0; n < len(encoded) || n == 0; n += kittyChunk {
+		end := n + kittyChunk
+		more := "1"
+		if end >= len(encoded) {
+			end = len(encoded)
+			more = "0"
+		}
+		out.WriteString("\x1b_G")
+		if n == 0 {
+			// a=T transmits and places the image in one go
+			out.WriteString("a=T,q=2,i=" + strconv.Itoa(k.id) + "," + control)
+			out.WriteString(",c=" + strconv.Itoa(cols) + ",r=" + strconv.Itoa(rows) + ",")
+		}
+		out.WriteString("m=" + more + ";")
+		out.WriteString(encoded[n:end])
+		out.WriteString("\x1b\\")
+	}
+
+	if old != 0 {
+		// d=I frees the image data too, not just the placement
+		out.WriteString("\x1b_Ga=d,d=I,q=2,i=" + strconv.Itoa(old) + "\x1b\\")
+	}
+	return out.Bytes()
+}
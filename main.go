@@ -9,6 +9,7 @@ import (
 	"github.com/gdamore/tcell/v2"
 	"github.com/rivo/tview"
 	_ "golang.org/x/image/bmp"
+	"golang.org/x/term"
 	"image"
 	"image/color"
 	_ "image/jpeg"
@@ -17,6 +18,7 @@ import (
 	"os"
 	"os/exec"
 	"os/signal"
+	"runtime"
 	"strconv"
 	"strings"
 	"syscall"
@@ -26,7 +28,36 @@ import (
 const UPPER_HALF_BLOCK = "▀"
 
 var imageMagic = [][]byte{{0x89, 0x50, 0x4E, 0x47, 0x0D}, {0x42, 0x4D}, {0xFF, 0xD8, 0xFF, 0xDB}, {0xFF, 0xD8, 0xFF, 0xE0},
-	{0xFF, 0xD8, 0xFF, 0xEE}, {0xFF, 0xD8, 0xFF, 0xE1}, {0xFF, 0xD8, 0xFF, 0xE0}}
+	{0xFF, 0xD8, 0xFF, 0xEE}, {0xFF, 0xD8, 0xFF, 0xE1}, {0xFF, 0xD8, 0xFF, 0xE0}, []byte("GIF87a"), []byte("GIF89a")}
+
+// isImage reports whether data starts like a PNG, BMP, JPEG or GIF file
+func isImage(data []byte) bool {
+	if len(data) > 16 {
+		data = data[0:16]
+	}
+	for _, magic := range imageMagic {
+		if bytes.Contains(data, magic) {
+			return true
+		}
+	}
+	return false
+}
+
+// mediaTypeOf picks how a file is played: audio, animation, image or video.
+// An animation is decoded on the way and returned too.
+func mediaTypeOf(data []byte) (string, *animation) {
+	if len(data) >= 4 && bytes.Contains(data[0:4], []byte("ID3")) {
+		return "audio", nil
+	}
+	// checked before isImage, which would only show the first frame
+	if anim, err := decodeAnimation(data); err == nil {
+		return "animation", anim
+	}
+	if isImage(data) {
+		return "image", nil
+	}
+	return "video", nil
+}
 
 var size int
 var i int
@@ -56,67 +87,77 @@ func secondsToMinutes(inSeconds int) string {
 	return str
 }
 
-// 48;2;r;g;bm - set background colour to rgb
-func rgbBackgroundSequence(r, g, b uint8) string {
-	return fmt.Sprintf("\x1b[48;2;%d;%d;%dm", r, g, b)
-}
-
-// 38;2;r;g;bm - set text colour to rgb
-func rgbTextSequence(r, g, b uint8) string {
-	return fmt.Sprintf("\x1b[38;2;%d;%d;%dm", r, g, b)
-}
-
-func resetColorSequence() string {
-	return "\x1b[0m"
+// pixelAt reads a premultiplied pixel, without the interface conversions
+// of img.At for the *image.RGBA pictures the scaler produces
+func pixelAt(img image.Image, x, y int) color.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok {
+		if !(image.Point{x, y}.In(rgba.Rect)) {
+			return color.RGBA{}
+		}
+		off := rgba.PixOffset(x, y)
+		return color.RGBA{rgba.Pix[off], rgba.Pix[off+1], rgba.Pix[off+2], rgba.Pix[off+3]}
+	}
+	return color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
 }
 
-func convertColorToRGB(col color.Color) (uint8, uint8, uint8) {
-	rgbaColor := color.RGBAModel.Convert(col)
-	_r, _g, _b, _ := rgbaColor.RGBA()
-	// rgb values are uint8s, I cannot comprehend why the stdlib would return
-	// int32s :facepalm:
-	r := uint8(_r & 0xFF)
-	g := uint8(_g & 0xFF)
-	b := uint8(_b & 0xFF)
-	return r, g, b
+// opaque makes a pixel either fully transparent or fully opaque, cells
+// don't have anything in between
+func opaque(a uint8) uint8 {
+	if a == 0 {
+		return 0
+	}
+	return 0xFF
 }
 
-func convertImageToANSI(img image.Image, skip int) string {
+// convertImageToCells samples every skip+1 pixel of img into a grid of cells
+// of the given mode
+func convertImageToCells(img image.Image, skip int, mode cellMode) *cellFrame {
 	// We'll just reuse this to increment the loop counters
 	skip += 1
-	ansi := resetColorSequence()
-	yMax := img.Bounds().Max.Y
+	if mode.Name == "ascii" {
+		return convertImageToASCII(img, skip, mode)
+	}
 	xMax := img.Bounds().Max.X
+	cols := (img.Bounds().Dx() + mode.Width*skip - 1) / (mode.Width * skip)
+	rows := (img.Bounds().Dy() + mode.Height*skip - 1) / (mode.Height * skip)
+	frame := newCellFrame(cols, rows)
+
+	// every band of rows gets its own goroutine, they only write their own cells
+	forEachBand(rows, func(_ int, band rowBand) {
+		pix := make([]color.RGBA, mode.Width*mode.Height)
+		for row := band.Lo; row < band.Hi; row++ {
+			y := img.Bounds().Min.Y + row*mode.Height*skip
+			for col, x := 0, img.Bounds().Min.X; x < xMax; col, x = col+1, x+mode.Width*skip {
+				c := frame.At(col, row)
+				if mode.Name != "half" {
+					*c = subcellAt(img, x, y, skip, mode, pix)
+					continue
+				}
 
-	sequences := make([]string, yMax)
-
-	for y := img.Bounds().Min.Y; y < yMax; y += 2 * skip {
-		sequence := ""
-		for x := img.Bounds().Min.X; x < xMax; x += skip {
-			upperPix := img.At(x, y)
-			lowerPix := img.At(x, y+skip)
-
-			ur, ug, ub := convertColorToRGB(upperPix)
-			lr, lg, lb := convertColorToRGB(lowerPix)
-
-			if y+skip >= yMax {
-				sequence += resetColorSequence()
-			} else {
-				sequence += rgbBackgroundSequence(lr, lg, lb)
+				// the last line of an odd height picture has no lower half, it reads
+				// as transparent
+				upper := pixelAt(img, x, y)
+				lower := pixelAt(img, x, y+skip)
+				upper.A = opaque(upper.A)
+				lower.A = opaque(lower.A)
+
+				switch {
+				case upper.A != 0:
+					c.Glyph = UPPER_HALF_BLOCK
+					c.Fg = upper
+					c.Bg = lower
+				case lower.A != 0:
+					// the foreground can't be left to the terminal, draw the lower half
+					c.Glyph = "▄"
+					c.Fg = lower
+				default:
+					c.Glyph = " "
+				}
 			}
-
-			sequence += rgbTextSequence(ur, ug, ub)
-			sequence += UPPER_HALF_BLOCK
-
-			sequences[y] = sequence
 		}
-	}
+	})
 
-	for y := img.Bounds().Min.Y; y < yMax; y += 2 * skip {
-		ansi += sequences[y] + resetColorSequence() + "\n"
-	}
-
-	return ansi
+	return frame
 }
 
 func openImage(picture []byte) image.Image {
@@ -130,12 +171,82 @@ func openImage(picture []byte) image.Image {
 }
 
 func renderPicture(picture []byte) string {
+	return renderFrame(picture).ANSI()
+}
+
+// renderFrame decodes a picture and converts it to cells in the current mode
+func renderFrame(picture []byte) *cellFrame {
+	return renderImage(openImage(picture))
+}
+
+// renderImage converts a decoded picture to cells in the current mode
+func renderImage(img image.Image) *cellFrame {
 	if skip == 0 {
 		skip = 7
 	}
-	img := openImage(picture)
-	str := convertImageToANSI(img, skip)
-	return str
+	full := img.Bounds()
+	img = zoomCrop(img)
+	mode := cellModes[cellModeIndex]
+	var cols, rows int
+	var small *image.RGBA
+	if fitScreen {
+		cols, rows = fitArea()
+		small = fitImage(img, mode, cols, rows)
+	} else if zoomLevel > 1 {
+		// the visible part takes the space of the whole picture
+		small = resize(img, (full.Dx()+skip)/(skip+1), (full.Dy()+skip)/(skip+1))
+	} else if resampleFilter != "point" {
+		small = shrink(img, skip)
+	} else {
+		small = downsample(img, skip)
+	}
+	flattenAlpha(small, 4)
+	adjustTone(small)
+	applyFilters(small)
+	if colorDepth != "truecolor" && ditherMode != "none" {
+		ditherImage(small, quantizeColor, ditherSpread())
+	}
+	frame := convertImageToCells(small, 0, mode)
+	if fitScreen {
+		frame = letterbox(frame, cols, rows)
+	}
+	return frame
+}
+
+// seekKeys and frameKeys are the legends under the help line of statusText,
+// a and d seek in videos and audio but step one frame in animations
+const (
+	seekKeys  = " Rewind  |   pause  |  Fast Fwd  |  quit  | scale ▲  | scale ▼  | mode  | tone ▼▲ ↺ | filter | zoom pan ↺ "
+	frameKeys = " ◀ frame |   pause  |  frame ▶   |  quit  | scale ▲  | scale ▼  | mode  | tone ▼▲ ↺ | filter | zoom pan ↺ "
+)
+
+// statusText lays out the playback position, file name and key help with the
+// legend keys. The status view centres every line, so the two help lines have
+// the same width.
+func statusText(position, name, keys string) string {
+	help := "<--- 'a' | spacebar |  'd' --->  |  'q'   |   'f'    |   'r'    |  'm'  | b c g s 0 |  1-9   | + - hjkl z "
+	return position + zoomStatus() + toneStatus() + filterStatus() + "\n" + name + "\n" + help + "\n" + keys
+}
+
+// newStatusView is the text view for statusText, it is laid out again by
+// tview whenever the terminal changes size
+func newStatusView() *tview.TextView {
+	status := tview.NewTextView()
+	status.SetTextAlign(tview.AlignCenter)
+	status.SetText("Loading...")
+	return status
+}
+
+// playerLayout stacks the picture and the status lines. Pixel graphics are
+// written to the terminal under the status lines, text frames go above them
+// and the view is resized to each frame so the status follows right after.
+func playerLayout(view, status tview.Primitive) *tview.Flex {
+	layout := tview.NewFlex().SetDirection(tview.FlexRow)
+	layout.SetBackgroundColor(tcell.ColorDefault)
+	if outputMode != "ansi" {
+		return layout.AddItem(status, statusLines, 0, false).AddItem(view, 0, 1, false)
+	}
+	return layout.AddItem(view, 0, 1, false).AddItem(status, statusLines, 0, false)
 }
 
 // ExtractFrames Legacy frame extractor, uses ffmpeg to extract frames
@@ -152,15 +263,227 @@ func main() {
 	var scale = flag.Int("scale", 7, "Scale of the image")
 	var file = flag.String("file", "", "File to render")
 	var dl = flag.String("dl", "", "Download a video from Youtube")
+	var mode = flag.String("mode", "half", "Cell mode: half, quadrant, sextant, braille or ascii")
+	var colors = flag.String("colors", "truecolor", "Colour depth: truecolor, 256 or 16")
+	var dither = flag.String("dither", "none", "Dithering below truecolor: none, floyd or ordered")
+	var output = flag.String("output", "ansi", "Output: ansi, sixel, kitty or iterm")
+	var threshold = flag.Int("threshold", 8, "Colour change (0-255) a cell needs before it is redrawn")
+	var fit = flag.Bool("fit", false, "Scale the picture to fill the terminal")
+	var aspect = flag.Float64("cellaspect", 2.0, "Height of a terminal cell divided by its width")
+	var filter = flag.String("filter", "box", "Scaling filter: point, box, bilinear or lanczos")
+	var alpha = flag.String("alpha", "terminal", "Transparency: terminal, blend or checker")
+	var bgcolor = flag.String("bgcolor", "#000000", "Colour transparent pixels are blended with")
+	var ramp = flag.String("ramp", string(asciiRamp), "Glyphs of the ascii mode, darkest first")
+	var asciicolor = flag.Bool("asciicolor", true, "Colour the glyphs of the ascii mode")
+	var edges = flag.Bool("edges", false, "Draw edges with / \\ | - in the ascii mode")
+	var bright = flag.Float64("brightness", 0, "Brightness added to every pixel, -1 to 1")
+	var contr = flag.Float64("contrast", 1, "Contrast around mid grey, 1 leaves it as it is")
+	var gam = flag.Float64("gamma", 1, "Gamma, above 1 brightens the shadows")
+	var sat = flag.Float64("saturation", 1, "Colour saturation, 0 is greyscale")
+	var vf = flag.String("vf", "", "Filters applied to every frame, comma separated: "+strings.Join(filterNames, ", "))
+	var cvd = flag.String("cvd", "none", "Simulate colour vision: none, "+strings.Join(cvdTypes, ", "))
+	var daltonize = flag.Bool("daltonize", false, "Correct the colours for -cvd instead of simulating it")
+	var viewer = flag.Bool("view", false, "Show pictures in an interactive viewer that can zoom and pan")
+	var dir = flag.String("dir", "", "Show the pictures in a directory in the viewer")
+	var slideshow = flag.Duration("slideshow", 0, "Time each picture is shown in the viewer before the next, 0 waits for a key")
+	var sortFlag = flag.String("sort", "name", "Order of the pictures in the viewer: name or date")
+	var record = flag.String("record", "", "Record playback to an asciicast v2 file")
+	var headlessFlag = flag.Bool("headless", false, "Play without a terminal UI or sound, frames go to stdout, -record or -export")
+	var frames = flag.Int("frames", 0, "Stop headless playback after this many frames, 0 plays all of them")
+	var export = flag.String("export", "", "Write the rendered frames to a .gif or a video file, -output ansi only")
+	var cellsize = flag.String("cellsize", "8x16", "Size of a cell in pixels for -export")
+	var fontFlag = flag.String("font", "", "TrueType or OpenType font for the glyphs in -export")
+	var thumbs = flag.Int("thumbs", 0, "Show this many frames from all through a video in a grid instead of playing it")
+	var sheet = flag.String("sheet", "", "Write the -thumbs grid to a PNG file")
+	var workers = flag.Int("workers", runtime.NumCPU(), "Goroutines used to render a frame")
 	var mediaType string
+	var anim *animation
 	ctx := context.Background()
 	ctx, cancel := context.WithCancel(ctx)
 	ctx.Done()
 	flag.Parse()
 
+	if flag.Arg(0) == "probe" {
+		os.Exit(probeCommand(flag.Args()[1:]))
+	}
+
+	if n, ok := findCellMode(*mode); ok {
+		cellModeIndex = n
+	} else {
+		fmt.Println("Unknown mode: " + *mode)
+		os.Exit(1)
+	}
+	if !validColorDepth(*colors) {
+		fmt.Println("Unknown colour depth: " + *colors)
+		os.Exit(1)
+	}
+	colorDepth = *colors
+	if !validDitherMode(*dither) {
+		fmt.Println("Unknown dither mode: " + *dither)
+		os.Exit(1)
+	}
+	ditherMode = *dither
+	if !validOutputMode(*output) {
+		fmt.Println("Unknown output: " + *output)
+		os.Exit(1)
+	}
+	outputMode = *output
+	changeThreshold = *threshold
+	fitScreen = *fit
+	if *aspect <= 0 {
+		fmt.Println("Cell aspect has to be above 0")
+		os.Exit(1)
+	}
+	cellAspect = *aspect
+	if !validResampleFilter(*filter) {
+		fmt.Println("Unknown filter: " + *filter)
+		os.Exit(1)
+	}
+	resampleFilter = *filter
+	if !validAlphaMode(*alpha) {
+		fmt.Println("Unknown alpha mode: " + *alpha)
+		os.Exit(1)
+	}
+	alphaMode = *alpha
+	if len([]rune(*ramp)) < 2 {
+		fmt.Println("Ramp needs at least two glyphs: " + *ramp)
+		os.Exit(1)
+	}
+	asciiRamp = []rune(*ramp)
+	asciiColor = *asciicolor
+	asciiEdges = *edges
+	brightness, contrast, gamma, saturation = *bright, *contr, *gam, *sat
+	clampTone()
+	if chain, entry, ok := parseFilters(*vf); ok {
+		filterChain = chain
+	} else {
+		fmt.Println("Unknown filter: " + entry)
+		os.Exit(1)
+	}
+	if _, ok := cvdMatrices[*cvd]; ok {
+		// last in the chain, it shows the frame as it is drawn
+		filterChain = append(filterChain, filterSlot{Filter: cvdFilter{kind: *cvd, daltonize: *daltonize}, Enabled: true})
+	} else if *cvd != "none" {
+		fmt.Println("Unknown colour vision deficiency: " + *cvd)
+		os.Exit(1)
+	}
+	if *daltonize && *cvd == "none" {
+		fmt.Println("-daltonize needs -cvd")
+		os.Exit(1)
+	}
+	if *record != "" && outputMode != "ansi" {
+		fmt.Println("Recording needs -output ansi")
+		os.Exit(1)
+	}
+	if *export != "" && outputMode != "ansi" {
+		fmt.Println("Exporting needs -output ansi")
+		os.Exit(1)
+	}
+	if w, h, ok := parseCellSize(*cellsize); ok {
+		exportCellW, exportCellH = w, h
+	} else {
+		fmt.Println("Cell size has to look like 8x16")
+		os.Exit(1)
+	}
+	if *fontFlag != "" {
+		face, err := loadExportFont(*fontFlag)
+		if err != nil {
+			fmt.Println("Unable to load font: " + err.Error())
+			os.Exit(1)
+		}
+		exportFace = face
+	}
+	exportName = *export
+	headless = *headlessFlag
+	if !term.IsTerminal(int(os.Stdout.Fd())) {
+		// nothing to draw tview on, frames are streamed as text instead
+		headless = true
+	}
+	if *export != "" {
+		// frames are exported at their time in the video, not as they are shown
+		headless = true
+	}
+	if *frames < 0 {
+		fmt.Println("Frames can't be below 0")
+		os.Exit(1)
+	}
+	maxFrames = *frames
+	if *thumbs < 0 {
+		fmt.Println("Thumbs can't be below 0")
+		os.Exit(1)
+	}
+	if *sheet != "" && *thumbs == 0 {
+		fmt.Println("A contact sheet needs -thumbs")
+		os.Exit(1)
+	}
+	if *thumbs > 0 && fitScreen && outputMode == "ansi" && *sheet == "" {
+		// the grid is as wide as the terminal, -scale sets the size of its tiles
+		fmt.Println("-thumbs can't be used with -fit, the grid already fills the terminal width")
+		os.Exit(1)
+	}
+	if *thumbs > 0 && (*dir != "" || flag.NArg() > 1) {
+		fmt.Println("Thumbnails can only be made of a video")
+		os.Exit(1)
+	}
+	thumbCount, thumbSheet = *thumbs, *sheet
+	if *workers < 1 {
+		*workers = 1
+	}
+	renderWorkers = *workers
+	if c, ok := parseHexColor(*bgcolor); ok {
+		alphaBackground = c
+	} else {
+		fmt.Println("Background colour has to look like #rrggbb")
+		os.Exit(1)
+	}
+
+	if !validImageSort(*sortFlag) {
+		fmt.Println("Unknown sort order: " + *sortFlag)
+		os.Exit(1)
+	}
+	imageSort = *sortFlag
+	if *slideshow < 0 {
+		fmt.Println("Slideshow interval can't be below 0")
+		os.Exit(1)
+	}
+	slideshowInterval = *slideshow
+
+	// a directory or more than one file opens the viewer
+	if *dir != "" || flag.NArg() > 1 {
+		var names []string
+		if *dir != "" {
+			var err error
+			if names, err = imageFiles(*dir); err != nil {
+				log.Fatal(err)
+			}
+		}
+		for _, name := range flag.Args() {
+			if isImageFile(name) {
+				names = append(names, name)
+			}
+		}
+		if len(names) == 0 {
+			fmt.Println("No pictures found")
+			os.Exit(1)
+		}
+		sortImages(names)
+		skip = *scale
+		if headless {
+			// no terminal to view them in, they are printed one after the other
+			for _, name := range names {
+				data, _ := os.ReadFile(name)
+				fmt.Println(name)
+				fmt.Println(renderPicture(data))
+			}
+			os.Exit(0)
+		}
+		viewImages(names)
+		os.Exit(0)
+	}
+
 	if *file == "" && *dl == "" {
-		if _, err := os.Stat(os.Args[1]); err == nil {
-			*file = os.Args[1]
+		if _, err := os.Stat(flag.Arg(0)); err == nil && flag.NArg() > 0 {
+			*file = flag.Arg(0)
 		} else {
 			fmt.Println("No file specified")
 			os.Exit(1)
@@ -177,8 +500,27 @@ func main() {
 		displayName = "Downloaded Video: " + *dl
 	}
 
+	// cleanup removes what playback leaves behind and finishes the recording
+	cleanup := func() {
+		wd, _ := os.Getwd()
+		os.RemoveAll(wd + "/frames")
+		if *dl != "" {
+			os.Remove("download.mp4")
+		}
+		if _, err := os.Stat("audio.mp3"); err == nil {
+			os.Remove("audio.mp3")
+		}
+		if recorder != nil {
+			recorder.Close()
+		}
+		if exporter != nil {
+			if err := exporter.Close(); err != nil {
+				log.Println("Unable to export: " + err.Error())
+			}
+		}
+	}
+
 	skip = *scale
-	boxNum := 0
 	paused = false
 
 	os.RemoveAll("frames")
@@ -192,24 +534,77 @@ func main() {
 	}
 
 	data, _ := os.ReadFile(*file)
-	for _, magic := range imageMagic {
-		if bytes.Contains(data[0:4], []byte("ID3")) {
-			mediaType = "audio"
-			break
+	mediaType, anim = mediaTypeOf(data)
+	if thumbCount > 0 && mediaType != "video" {
+		fmt.Println("Thumbnails can only be made of a video")
+		cleanup()
+		os.Exit(1)
+	}
+
+	if *record != "" {
+		// created once it is known there will be frames to write
+		if mediaType != "video" && mediaType != "animation" {
+			fmt.Println("Only videos and animations can be recorded")
+			cleanup()
+			os.Exit(1)
 		}
-		if bytes.Contains(data[0:16], magic) {
-			mediaType = "image"
-			break
-		} else {
-			mediaType = "video"
+		var err error
+		if recorder, err = newCastRecorder(*record, displayName); err != nil {
+			fmt.Println("Unable to record: " + err.Error())
+			cleanup()
+			os.Exit(1)
 		}
 	}
 
 	if mediaType == "image" {
 		skip = *scale
+		// without a terminal to view it in, it is printed like any picture
+		if *viewer && !headless {
+			viewImages([]string{*file})
+			os.Exit(0)
+		}
+		if exportName != "" {
+			err := exportFrame(renderFrame(data))
+			if err == nil {
+				err = exporter.Close()
+			}
+			if err != nil {
+				log.Fatal("Unable to export: " + err.Error())
+			}
+			os.Exit(0)
+		}
+		if outputMode != "ansi" {
+			seq, cols, rows := renderGraphics(data)
+			if left, _ := graphicsOffset(cols, rows); left > 0 {
+				fmt.Printf("\x1b[%dC", left)
+			}
+			os.Stdout.Write(seq)
+			fmt.Println()
+			os.Exit(0)
+		}
 		fmt.Println(renderPicture(data))
 		os.Exit(0)
+	} else if mediaType == "animation" {
+		TotalDuration = int((anim.Duration() + time.Second - 1) / time.Second)
+		if headless {
+			c := make(chan os.Signal, 1)
+			signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+			playHeadless(len(anim.Frames), anim.Source(), frameKeys, c)
+		} else {
+			playAnimation(displayName, anim)
+		}
+		cleanup()
+		os.Exit(0)
 	} else if mediaType == "video" {
+		if thumbCount > 0 {
+			err := contactSheet(*file)
+			cleanup()
+			if err != nil {
+				log.Fatal(err)
+			}
+			os.Exit(0)
+		}
+		reservedRows = statusLines
 		s := spinner.New(spinner.CharSets[36], 100*time.Millisecond)
 		s.Prefix = "Extracting frames... "
 		s.Start()
@@ -220,6 +615,22 @@ func main() {
 		sa.Start()
 		VidToAudio(*file)
 		sa.Stop()
+		if headless {
+			if TotalDuration == 0 {
+				TotalDuration = GetMp3Length("audio.mp3")
+			}
+			delay, err := frameTime(NumberFrames, TotalDuration)
+			if err != nil {
+				fmt.Println(err)
+				cleanup()
+				os.Exit(1)
+			}
+			c := make(chan os.Signal, 1)
+			signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+			playHeadless(NumberFrames, extractedFrames(delay), seekKeys, c)
+			cleanup()
+			os.Exit(0)
+		}
 		audioPlayer := NewAudio("audio.mp3")
 		extractCheck := true
 		for extractCheck {
@@ -228,105 +639,37 @@ func main() {
 				time.Sleep(100 * time.Millisecond)
 			}
 		}
-		app := tview.NewApplication()
-		box := tview.NewTextView().SetDynamicColors(true)
-		box2 := tview.NewTextView().SetDynamicColors(true)
-		box2.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
-			if event.Rune() == 'd' {
+		// i and size are shared with the keys, so only used with p.mu held
+		var p *player
+		p = newPlayer(displayName, seekKeys, func(key rune) {
+			switch key {
+			case 'd':
 				i = i + 24
 				if i >= size {
 					i = size - 1
 				}
 				audioPlayer.ControlChannel <- "forward"
-			}
-			if event.Rune() == 'a' {
+			case 'a':
 				i = i - 24
 				if i < 0 {
 					i = 0
 				}
 				audioPlayer.ControlChannel <- "back"
-			}
-			if event.Rune() == ' ' {
+			case ' ':
 				paused = !paused
 				audioPlayer.ControlChannel <- "pause"
-			}
-			if event.Rune() == 'q' {
+			case 'q':
 				cancel()
-				wd, _ := os.Getwd()
-				os.RemoveAll(wd + "/frames")
-				if *dl != "" {
-					os.Remove("download.mp4")
+				if outputMode == "kitty" {
+					os.Stdout.Write(kitty.Clear())
 				}
-				if _, err := os.Stat("audio.mp3"); err == nil {
-					os.Remove("audio.mp3")
-				}
-				app.Stop()
+				cleanup()
+				p.app.Stop()
 				os.Exit(0)
 			}
-			if event.Rune() == 'f' {
-				skip--
-				if skip < 1 {
-					skip = 1
-				}
-			}
-			if event.Rune() == 'r' {
-				skip++
-				if skip > 10 {
-					skip = 10
-				}
-			}
-			return event
 		})
-		box.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
-			if event.Rune() == 'd' {
-				i = i + 24
-				if i >= size {
-					i = size - 1
-				}
-				audioPlayer.ControlChannel <- "forward"
-			}
-			if event.Rune() == 'a' {
-				i = i - 24
-				if i < 0 {
-					i = 0
-				}
-				audioPlayer.ControlChannel <- "back"
-			}
-			if event.Rune() == ' ' {
-				paused = !paused
-				audioPlayer.ControlChannel <- "pause"
-			}
-			if event.Rune() == 'q' {
-				cancel()
-				wd, _ := os.Getwd()
-				os.RemoveAll(wd + "/frames")
-				if *dl != "" {
-					os.Remove("download.mp4")
-				}
-				if _, err := os.Stat("audio.mp3"); err == nil {
-					os.Remove("audio.mp3")
-				}
-				app.Stop()
-				os.Exit(0)
-			}
-			if event.Rune() == 'f' {
-				skip--
-				if skip < 1 {
-					skip = 1
-				}
-			}
-			if event.Rune() == 'r' {
-				skip++
-				if skip > 10 {
-					skip = 10
-				}
-			}
-			return event
-		})
-		c := make(chan os.Signal)
+		c := make(chan os.Signal, 1)
 		signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-		box2.SetText("Loading...")
-		box.SetText("Loading...")
 
 		if TotalDuration == 0 {
 			TotalDuration = GetMp3Length("audio.mp3")
@@ -341,88 +684,86 @@ func main() {
 				if err != nil {
 					log.Fatal(err)
 				}
+				p.mu.Lock()
 				size = len(files)
+				p.mu.Unlock()
 				time.Sleep(100 * time.Millisecond)
 			}
 		}()
+		// stop ends the playback loop, which closes stopped once it is out of
+		// the recorder and the frames directory
+		stop, stopped := make(chan struct{}), make(chan struct{})
+		i = 1
+		go p.run()
 		go func() {
-			loopCheck := true
-			go app.SetRoot(box, true).Run()
-			i = 1
-			for loopCheck {
-				if paused {
+			defer close(stopped)
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				p.mu.Lock()
+				wait := paused && !resized
+				n, last := i, size
+				p.mu.Unlock()
+				if wait {
+					time.Sleep(10 * time.Millisecond)
 					continue
 				}
-				if i != size {
-					start := time.Now()
-					buf, _ := os.ReadFile("frames/" + strconv.Itoa(i) + ".jpg")
-					if boxNum == 0 {
-						app.QueueUpdateDraw(
-							func() {
-								text := renderPicture(buf)
-								w := len(strings.Split(text, "\n")[0])
-								spaceb := w - 37
-								if spaceb < 0 {
-									spaceb = 0
-								}
-								spacet := w - 10
-								if spacet < 0 {
-									spacet = 0
-								}
-								spacert := strings.Repeat(" ", spacet/100)
-								spacerb := strings.Repeat(" ", spaceb/100)
-								ansi := tview.TranslateANSI(text)
-								box.SetText(ansi + "  " + spacert + secondsToMinutes(i/mpf) + "/" + secondsToMinutes(TotalDuration) +
-									"\n" + spacerb + "FileName: " + displayName +
-									"\n" + spacerb + "<--- 'a' | spacebar |  'd' --->  |  'q'   |   'f'    |   'r'" +
-									"\n" + spacerb + " Rewind  |   pause  |  Fast Fwd  |  quit  | scale ▲  | scale ▼")
-								boxNum = 1
-								app.SetRoot(box2, true)
-							})
-					} else {
-						app.QueueUpdateDraw(
-							func() {
-								text := renderPicture(buf)
-								w := len(strings.Split(text, "\n")[0])
-								spaceb := w - 37
-								if spaceb < 0 {
-									spaceb = 0
-								}
-								spacet := w - 10
-								if spacet < 0 {
-									spacet = 0
-								}
-								spacert := strings.Repeat(" ", spacet/100)
-								spacerb := strings.Repeat(" ", spaceb/100)
-								ansi := tview.TranslateANSI(text)
-								box2.SetText(ansi + "  " + spacert + secondsToMinutes(i/mpf) + "/" + secondsToMinutes(TotalDuration) +
-									"\n" + spacerb + "FileName: " + displayName +
-									"\n" + spacerb + "<--- 'a' | spacebar |  'd' --->  |  'q'   |   'f'    |   'r'" +
-									"\n" + spacerb + " Rewind  |   pause  |  Fast Fwd  |  quit  | scale ▲  | scale ▼")
-								boxNum = 0
-								app.SetRoot(box, true)
-							})
-					}
-					for time.Now().Sub(start) < (time.Duration(mpf) * time.Millisecond) {
-						time.Sleep(1 * time.Millisecond)
+				if n == last {
+					// extraction hasn't caught up yet
+					time.Sleep(time.Millisecond)
+					continue
+				}
+				start := time.Now()
+				buf, _ := os.ReadFile("frames/" + strconv.Itoa(n) + ".jpg")
+				p.show(openImage(buf), buf, secondsToMinutes(n/mpf)+"/"+secondsToMinutes(TotalDuration))
+
+				p.mu.Lock()
+				wait = paused
+				p.mu.Unlock()
+				if wait {
+					continue
+				}
+				for time.Now().Sub(start) < (time.Duration(mpf) * time.Millisecond) {
+					time.Sleep(1 * time.Millisecond)
+				}
+				p.mu.Lock()
+				if outputMode != "ansi" {
+					// encoding pixels can be slower than the frame rate, drop frames
+					// instead of letting the video fall behind the audio
+					behind := int(time.Now().Sub(start)/(time.Duration(mpf)*time.Millisecond)) - 1
+					if i+behind < size {
+						i += behind
 					}
-					i++
 				}
+				i++
+				p.mu.Unlock()
 			}
 		}()
 		<-c
-		app.Stop()
+		close(stop)
+		<-stopped
+		cleanup()
+		p.app.Stop()
 		time.Sleep(100 * time.Millisecond)
 		os.Exit(0)
 	} else if mediaType == "audio" {
+		if headless {
+			// the visualizer follows the sound, which headless playback doesn't play
+			fmt.Println("Audio files need a terminal, they can't be played headless")
+			cleanup()
+			os.Exit(1)
+		}
+		reservedRows = statusLines
 		audioPlayer := NewAudio(*file)
 		audioPlayer.IgnoreSync = true
 		app := tview.NewApplication()
-		box := tview.NewTextView()
-		box.SetDynamicColors(true)
-		box.SetText("Loading...")
+		view := newCellView()
+		status := newStatusView()
 
-		box.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		app.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
 			if event.Rune() == 'd' {
 				i = i + 24
 				if i >= size {
@@ -458,10 +799,17 @@ func main() {
 					skip = 10
 				}
 			}
+			if event.Rune() == 'm' {
+				cellModeIndex = (cellModeIndex + 1) % len(cellModes)
+			}
+			toneKey(event.Rune())
+			toggleFilter(event.Rune())
+			zoomKey(event)
 			return event
 		})
 		go audioPlayer.Start(ctx)
-		go app.SetRoot(box, true).Run()
+		layout := playerLayout(view, status)
+		go app.SetRoot(layout, true).Run()
 		var imageData []byte
 		imageData = Visualizer()
 		go func() {
@@ -475,25 +823,10 @@ func main() {
 			start := time.Now()
 			app.QueueUpdateDraw(
 				func() {
-
-					text := renderPicture(imageData)
-					w := len(strings.Split(text, "\n")[0])
-					spaceb := w - 37
-					if spaceb < 0 {
-						spaceb = 0
-					}
-					spacet := w - 10
-					if spacet < 0 {
-						spacet = 0
-					}
-					spacert := strings.Repeat(" ", spacet/100)
-					spacerb := strings.Repeat(" ", spaceb/100)
-					ansi := tview.TranslateANSI(text)
-					box.SetText(ansi + "  " + spacert + secondsToMinutes(i/24) + "/" + secondsToMinutes(size) +
-						"\n" + spacerb + "File: " + *file +
-						"\n" + spacerb + "<--- 'a' | spacebar |  'd' --->  |  'q'   |   'f'    |   'r'" +
-						"\n" + spacerb + " Rewind  |   pause  |  Fast Fwd  |  quit  | scale ▲  | scale ▼")
-
+					frame := renderFrame(imageData)
+					view.SetFrame(frame)
+					layout.ResizeItem(view, frame.Height, 0)
+					status.SetText(statusText(secondsToMinutes(i/24)+"/"+secondsToMinutes(size), "File: "+*file, seekKeys))
 				})
 			for time.Now().Sub(start) < (40 * time.Millisecond) {
 				time.Sleep(1 * time.Millisecond)
@@ -9,6 +9,8 @@ import (
 	"io"
 	"log"
 	"os"
+	"time"
+	"unsafe"
 )
 
 var (
@@ -44,7 +46,8 @@ func ExtractImages(srcFileName string, ctx context.Context) int {
 
 	cc.SetTimeBase(gmf.AVR{Num: 1, Den: 1})
 
-	cc.SetPixFmt(gmf.AV_PIX_FMT_RGBA).SetWidth(srcVideoStream.CodecCtx().Width() / (6 / 3)).SetHeight(srcVideoStream.CodecCtx().Height() / (6 / 3))
+	divisor := frameDivisor(srcVideoStream.CodecCtx().Width(), srcVideoStream.CodecCtx().Height())
+	cc.SetPixFmt(gmf.AV_PIX_FMT_RGBA).SetWidth(srcVideoStream.CodecCtx().Width() / divisor).SetHeight(srcVideoStream.CodecCtx().Height() / divisor)
 	if codec.IsExperimental() {
 		cc.SetStrictCompliance(gmf.FF_COMPLIANCE_EXPERIMENTAL)
 	}
@@ -177,3 +180,349 @@ func writeFile(b image.Image) {
 		log.Fatal(err)
 	}
 }
+
+// videoExporter encodes rasterized frames with ffmpeg, H.264 if it was built
+// with libx264 and MPEG-4 otherwise. The container is picked by the file name.
+type videoExporter struct {
+	octx   *gmf.FmtCtx
+	ost    *gmf.Stream
+	cc     *gmf.CodecCtx
+	swsctx *gmf.SwsCtx
+	rgba   *gmf.Frame
+	pix    []byte
+	width  int
+	height int
+	pts    int64
+}
+
+func newVideoExporter(name string, width, height int) (*videoExporter, error) {
+	// yuv420p needs even sizes
+	width += width % 2
+	height += height % 2
+
+	octx, err := gmf.NewOutputCtx(name)
+	if err != nil {
+		return nil, err
+	}
+	codec, err := gmf.FindEncoder("libx264")
+	if err != nil {
+		if codec, err = gmf.FindEncoder(gmf.AV_CODEC_ID_MPEG4); err != nil {
+			return nil, err
+		}
+	}
+
+	// timestamps are in milliseconds, animations don't show every frame for
+	// the same time
+	timeBase := gmf.AVR{Num: 1, Den: 1000}
+	cc := gmf.NewCodecCtx(codec)
+	if octx.IsGlobalHeader() {
+		cc.SetFlag(gmf.CODEC_FLAG_GLOBAL_HEADER)
+	}
+	if codec.IsExperimental() {
+		cc.SetStrictCompliance(gmf.FF_COMPLIANCE_EXPERIMENTAL)
+	}
+	cc.SetPixFmt(gmf.AV_PIX_FMT_YUV420P).SetWidth(width).SetHeight(height).SetTimeBase(timeBase).SetBitRate(4e6)
+	if err := cc.Open(nil); err != nil {
+		return nil, err
+	}
+
+	par := gmf.NewCodecParameters()
+	defer par.Free()
+	if err := par.FromContext(cc); err != nil {
+		return nil, err
+	}
+	ost := octx.NewStream(codec)
+	if ost == nil {
+		return nil, fmt.Errorf("unable to create a stream for %s", codec.LongName())
+	}
+	ost.CopyCodecPar(par)
+	ost.SetCodecCtx(cc)
+	ost.SetTimeBase(timeBase)
+	ost.SetRFrameRate(gmf.AVR{Num: 1000, Den: int(exportDelay / time.Millisecond)})
+	if err := octx.WriteHeader(); err != nil {
+		return nil, err
+	}
+
+	swsctx, err := gmf.NewSwsCtx(width, height, gmf.AV_PIX_FMT_RGBA, width, height, gmf.AV_PIX_FMT_YUV420P, gmf.SWS_BICUBIC)
+	if err != nil {
+		return nil, err
+	}
+	rgba := gmf.NewFrame().SetWidth(width).SetHeight(height).SetFormat(gmf.AV_PIX_FMT_RGBA)
+	if err := rgba.ImgAlloc(); err != nil {
+		return nil, err
+	}
+	// gmf only sets frame data one byte per cgo call. data[0] is the first
+	// field of an AVFrame, so its pixels are filled from Go instead, but only
+	// when the buffer is the packed RGBA the copy in Frame expects.
+	stride := rgba.LineSize(0)
+	if rgba.Format() != int(gmf.AV_PIX_FMT_RGBA) || stride < 4*width {
+		return nil, fmt.Errorf("unexpected frame layout, %d bytes per row for %d RGBA pixels", stride, width)
+	}
+	plane := *(*unsafe.Pointer)(unsafe.Pointer(rgba.GetRawFrame()))
+	if plane == nil {
+		return nil, fmt.Errorf("the frame has no pixel buffer")
+	}
+	pix := unsafe.Slice((*byte)(plane), stride*height)
+	return &videoExporter{octx: octx, ost: ost, cc: cc, swsctx: swsctx, rgba: rgba, pix: pix, width: width, height: height}, nil
+}
+
+func (v *videoExporter) Frame(img *image.RGBA) error {
+	// anything outside img stays black
+	stride := v.rgba.LineSize(0)
+	width := 4 * img.Rect.Dx()
+	if width > 4*v.width {
+		width = 4 * v.width
+	}
+	for y := 0; y < v.height; y++ {
+		row := v.pix[y*stride : y*stride+4*v.width]
+		n := 0
+		if y < img.Rect.Dy() {
+			n = copy(row, img.Pix[y*img.Stride:y*img.Stride+width])
+		}
+		for x := n; x < len(row); x++ {
+			row[x] = 0
+		}
+	}
+
+	yuv := gmf.NewFrame().SetWidth(v.width).SetHeight(v.height).SetFormat(gmf.AV_PIX_FMT_YUV420P)
+	if err := yuv.ImgAlloc(); err != nil {
+		return err
+	}
+	v.swsctx.Scale(v.rgba, yuv)
+	yuv.SetPts(v.pts)
+	v.pts += exportDelay.Milliseconds()
+	// Encode frees the frame
+	return v.write(v.cc.Encode([]*gmf.Frame{yuv}, -1))
+}
+
+func (v *videoExporter) write(packets []*gmf.Packet, err error) error {
+	if err != nil {
+		return err
+	}
+	for _, p := range packets {
+		p.SetPts(gmf.RescaleQ(p.Pts(), v.cc.TimeBase(), v.ost.TimeBase()))
+		p.SetDts(gmf.RescaleQ(p.Dts(), v.cc.TimeBase(), v.ost.TimeBase()))
+		p.SetStreamIndex(v.ost.Index())
+		err := v.octx.WritePacket(p)
+		p.Free()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (v *videoExporter) Close() error {
+	// drain the frames the encoder still holds
+	err := v.write(v.cc.Encode(nil, 1))
+	v.octx.WriteTrailer()
+	v.rgba.Free()
+	v.swsctx.Free()
+	v.cc.Free()
+	v.ost.Free()
+	v.octx.Free()
+	return err
+}
+
+// sampleFrames decodes n frames spread evenly through a video, scaled to
+// width pixels across, and the time in the video each of them is from
+func sampleFrames(name string, n, width int) ([]*image.RGBA, []time.Duration, error) {
+	inputCtx, err := gmf.NewInputCtx(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer inputCtx.Free()
+
+	ist, err := inputCtx.GetBestStream(gmf.AVMEDIA_TYPE_VIDEO)
+	if err != nil {
+		return nil, nil, fmt.Errorf("no video stream found in '%s'", name)
+	}
+	duration := inputCtx.Duration()
+	if duration <= 0 {
+		return nil, nil, fmt.Errorf("the length of '%s' is unknown", name)
+	}
+	icc := ist.CodecCtx()
+	height := width * icc.Height() / icc.Width()
+	if height < 1 {
+		height = 1
+	}
+
+	// frames come out of the raw video encoder as RGBA, like in ExtractImages
+	codec, err := gmf.FindEncoder(gmf.AV_CODEC_ID_RAWVIDEO)
+	if err != nil {
+		return nil, nil, err
+	}
+	cc := gmf.NewCodecCtx(codec)
+	defer gmf.Release(cc)
+	cc.SetTimeBase(gmf.AVR{Num: 1, Den: 1})
+	cc.SetPixFmt(gmf.AV_PIX_FMT_RGBA).SetWidth(width).SetHeight(height)
+	if err := cc.Open(nil); err != nil {
+		return nil, nil, err
+	}
+	defer cc.Free()
+	swsctx, err := gmf.NewSwsCtx(icc.Width(), icc.Height(), icc.PixFmt(), width, height, gmf.AV_PIX_FMT_RGBA, gmf.SWS_BICUBIC)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer swsctx.Free()
+
+	timeBase := ist.TimeBase().AVR()
+	start := ist.GetStartTime()
+	if start < 0 {
+		// no start time
+		start = 0
+	}
+	var images []*image.RGBA
+	var times []time.Duration
+	for k := 0; k < n; k++ {
+		// the middle of every part, the very first and last frames are often black
+		at := duration * (float64(k) + 0.5) / float64(n)
+		ts := start + int64(at*float64(timeBase.Den)/float64(timeBase.Num))
+		if err := inputCtx.SeekFile(ist, ts, ts, 0); err != nil {
+			return nil, nil, err
+		}
+		icc.FlushBuffers()
+		frame, err := decodeFrameAt(inputCtx, ist, ts)
+		if err != nil {
+			return nil, nil, err
+		}
+		pts := frame.Pts()
+		scaled, err := gmf.DefaultRescaler(swsctx, []*gmf.Frame{frame})
+		if err != nil {
+			return nil, nil, err
+		}
+		packets, err := cc.Encode(scaled, -1)
+		if err != nil || len(packets) == 0 {
+			return nil, nil, fmt.Errorf("unable to convert frame %d: %v", k+1, err)
+		}
+		img := image.NewRGBA(image.Rect(0, 0, width, height))
+		copy(img.Pix, packets[0].Data())
+		for _, p := range packets {
+			p.Free()
+		}
+		images = append(images, img)
+		if pts < start {
+			pts = ts
+		}
+		times = append(times, time.Duration(float64(pts-start)*float64(timeBase.Num)/float64(timeBase.Den)*float64(time.Second)))
+	}
+	return images, times, nil
+}
+
+// decodeFrameAt decodes from where the input was seeked to until the frame at
+// ts, or the last one if the video ends before it
+func decodeFrameAt(inputCtx *gmf.FmtCtx, ist *gmf.Stream, ts int64) (*gmf.Frame, error) {
+	var last *gmf.Frame
+	for {
+		pkt, err := inputCtx.GetNextPacket()
+		if err != nil && err != io.EOF {
+			if pkt != nil {
+				pkt.Free()
+			}
+			return nil, err
+		}
+		if pkt != nil && pkt.StreamIndex() != ist.Index() {
+			pkt.Free()
+			continue
+		}
+
+		// a nil packet drains the decoder at the end of the file
+		frames, err := ist.CodecCtx().Decode(pkt)
+		if pkt != nil {
+			pkt.Free()
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, frame := range frames {
+			if last != nil {
+				last.Free()
+			}
+			last = frame
+		}
+		if last != nil && last.Pts() >= ts {
+			return last, nil
+		}
+		if pkt == nil {
+			if last == nil {
+				return nil, fmt.Errorf("no frame found at %d", ts)
+			}
+			return last, nil
+		}
+	}
+}
+
+// probeMedia fills report with what ffmpeg finds in name: the length, bit rate
+// and every stream with its codec
+func probeMedia(name string, report *probeReport) error {
+	gmf.LogSetLevel(gmf.AV_LOG_QUIET)
+	inputCtx, err := gmf.NewInputCtx(name)
+	if err != nil {
+		return err
+	}
+	defer inputCtx.Free()
+
+	if d := inputCtx.Duration(); d > 0 {
+		report.Duration = d
+	}
+	report.BitRate = inputCtx.BitRate()
+	for i := 0; i < inputCtx.StreamsCnt(); i++ {
+		st, err := inputCtx.GetStream(i)
+		if err != nil {
+			return err
+		}
+		// everything but the sample rate and channels is in the parameters,
+		// no decoder is opened, which gmf panics on when it fails
+		par := st.GetCodecPar()
+		stream := probeStream{
+			Index:   i,
+			Type:    streamTypes[st.Type()],
+			BitRate: par.BitRate(),
+		}
+		if stream.Type == "" {
+			stream.Type = "unknown"
+		}
+		if st.IsVideo() {
+			// gmf says 1 when the container doesn't store the count
+			if n := st.NbFrames(); n > 1 {
+				stream.Frames = n
+			}
+			stream.Width, stream.Height = par.Width(), par.Height()
+			rate := st.GetAvgFrameRate().AVR()
+			if rate.Num == 0 || rate.Den == 0 {
+				rate = st.GetRFrameRate().AVR()
+			}
+			if rate.Den != 0 {
+				stream.FrameRate = float64(rate.Num) / float64(rate.Den)
+			}
+		}
+		// streams without a decoder, like some subtitles, only have a type
+		if codec, err := gmf.FindDecoder(par.CodecId()); err == nil {
+			stream.Codec = codec.Name()
+			stream.CodecLongName = codec.LongName()
+			if st.IsAudio() {
+				// copying the parameters into a context that isn't opened is
+				// enough to read them
+				if cc := gmf.NewCodecCtx(codec); cc != nil {
+					if par.ToContext(cc) == nil {
+						stream.SampleRate, stream.Channels = cc.SampleRate(), cc.Channels()
+					}
+					cc.Free()
+				}
+			}
+		}
+		st.Free()
+		report.Streams = append(report.Streams, stream)
+	}
+	return nil
+}
+
+// streamTypes names the AVMEDIA_TYPE values, gmf only has constants for video
+// and audio, the others are data, subtitle and attachment
+var streamTypes = map[int32]string{
+	gmf.AVMEDIA_TYPE_VIDEO: "video",
+	gmf.AVMEDIA_TYPE_AUDIO: "audio",
+	2:                      "data",
+	3:                      "subtitle",
+	4:                      "attachment",
+}
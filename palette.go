@@ -0,0 +1,103 @@
+package main
+
+import (
+	"image/color"
+)
+
+// colorDepth selects which SGR colour codes are emitted: "truecolor" for
+// 38;2/48;2, "256" for the xterm-256 palette and "16" for the basic ANSI colours
+var colorDepth = "truecolor"
+
+var colorDepths = []string{"truecolor", "256", "16"}
+
+// the six levels used by each channel of the xterm 6x6x6 colour cube
+var cubeLevels = []uint8{0, 95, 135, 175, 215, 255}
+
+// xterm defaults for the 16 ANSI colours, terminals are free to change these
+var ansi16 = []color.RGBA{
+	{0, 0, 0, 255}, {205, 0, 0, 255}, {0, 205, 0, 255}, {205, 205, 0, 255},
+	{0, 0, 238, 255}, {205, 0, 205, 255}, {0, 205, 205, 255}, {229, 229, 229, 255},
+	{127, 127, 127, 255}, {255, 0, 0, 255}, {0, 255, 0, 255}, {255, 255, 0, 255},
+	{92, 92, 255, 255}, {255, 0, 255, 255}, {0, 255, 255, 255}, {255, 255, 255, 255},
+}
+
+func validColorDepth(depth string) bool {
+	for _, d := range colorDepths {
+		if d == depth {
+			return true
+		}
+	}
+	return false
+}
+
+func nearestCubeLevel(v uint8) int {
+	// the cube levels are 40 apart after the first step
+	if v < 48 {
+		return 0
+	}
+	if v < 115 {
+		return 1
+	}
+	return int(v-35) / 40
+}
+
+// nearest256 returns the closest colour of the xterm-256 palette, ignoring the
+// first 16 entries as they are configurable in most terminals
+func nearest256(c color.RGBA) (int, color.RGBA) {
+	r, g, b := nearestCubeLevel(c.R), nearestCubeLevel(c.G), nearestCubeLevel(c.B)
+	cube := color.RGBA{cubeLevels[r], cubeLevels[g], cubeLevels[b], 255}
+	cubeIndex := 16 + 36*r + 6*g + b
+
+	// greyscale ramp runs from 8 to 238 in steps of 10
+	avg := (int(c.R) + int(c.G) + int(c.B)) / 3
+	greyIndex := (avg - 3) / 10
+	if greyIndex < 0 {
+		greyIndex = 0
+	}
+	if greyIndex > 23 {
+		greyIndex = 23
+	}
+	level := uint8(8 + 10*greyIndex)
+	grey := color.RGBA{level, level, level, 255}
+
+	if colorDistance(c, grey) < colorDistance(c, cube) {
+		return 232 + greyIndex, grey
+	}
+	return cubeIndex, cube
+}
+
+func nearest16(c color.RGBA) (int, color.RGBA) {
+	best := 0
+	for n, p := range ansi16 {
+		if colorDistance(c, p) < colorDistance(c, ansi16[best]) {
+			best = n
+		}
+	}
+	return best, ansi16[best]
+}
+
+// quantizeColor maps a colour onto the palette of the current colour depth
+func quantizeColor(c color.RGBA) color.RGBA {
+	switch colorDepth {
+	case "256":
+		_, q := nearest256(c)
+		return q
+	case "16":
+		_, q := nearest16(c)
+		return q
+	}
+	return c
+}
+
+// paletteColor returns entry n of the xterm-256 palette
+func paletteColor(n int) color.RGBA {
+	switch {
+	case n < 16:
+		return ansi16[n]
+	case n < 232:
+		n -= 16
+		return color.RGBA{cubeLevels[n/36], cubeLevels[n/6%6], cubeLevels[n%6], 255}
+	}
+	level := uint8(8 + 10*(n-232))
+	return color.RGBA{level, level, level, 255}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"runtime"
+	"sync"
+)
+
+// renderWorkers is how many goroutines share the work on a frame
+var renderWorkers = runtime.NumCPU()
+
+// rowBand is a run of rows, from Lo up to but not including Hi
+type rowBand struct {
+	Lo int
+	Hi int
+}
+
+// rowBands splits n rows into one band per worker
+func rowBands(n int) []rowBand {
+	workers := renderWorkers
+	if workers > n {
+		workers = n
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	size := (n + workers - 1) / workers
+	bands := make([]rowBand, 0, workers)
+	for lo := 0; lo < n; lo += size {
+		hi := lo + size
+		if hi > n {
+			hi = n
+		}
+		bands = append(bands, rowBand{lo, hi})
+	}
+	return bands
+}
+
+// forEachBand runs fn for every band of n rows, each on its own goroutine,
+// and waits for all of them. fn gets the index of the band as well.
+func forEachBand(n int, fn func(index int, band rowBand)) {
+	bands := rowBands(n)
+	if len(bands) <= 1 {
+		for index, band := range bands {
+			fn(index, band)
+		}
+		return
+	}
+	var wg sync.WaitGroup
+	for index, band := range bands {
+		wg.Add(1)
+		go func(index int, band rowBand) {
+			defer wg.Done()
+			fn(index, band)
+		}(index, band)
+	}
+	wg.Wait()
+}
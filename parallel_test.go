@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image/png"
+	"testing"
+)
+
+// TestParallelMatchesSerial renders the same picture on one goroutine and on
+// several, in every cell mode and dither mode. The bands have to join up
+// without a seam, the error diffusion of floyd runs across their edges.
+func TestParallelMatchesSerial(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, testImage(241, 187)); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	saved := []interface{}{renderWorkers, skip, colorDepth, ditherMode, cellModeIndex, asciiEdges, filterChain}
+	defer func() {
+		renderWorkers = saved[0].(int)
+		skip = saved[1].(int)
+		colorDepth = saved[2].(string)
+		ditherMode = saved[3].(string)
+		cellModeIndex = saved[4].(int)
+		asciiEdges = saved[5].(bool)
+		filterChain = saved[6].([]filterSlot)
+	}()
+
+	skip = 1
+	chains := []string{"", "edge,pixelate=3"}
+	for mode := range cellModes {
+		for _, dither := range ditherModes {
+			for _, depth := range colorDepths {
+				for _, spec := range chains {
+					name := fmt.Sprintf("%s/%s/%s/%q", cellModes[mode].Name, dither, depth, spec)
+					t.Run(name, func(t *testing.T) {
+						cellModeIndex, ditherMode, colorDepth = mode, dither, depth
+						asciiEdges = cellModes[mode].Name == "ascii"
+						filterChain, _, _ = parseFilters(spec)
+
+						renderWorkers = 1
+						serial := renderFrame(data).ANSI()
+						for _, workers := range []int{2, 3, 8} {
+							renderWorkers = workers
+							if parallel := renderFrame(data).ANSI(); parallel != serial {
+								t.Fatalf("%d workers differ from one", workers)
+							}
+						}
+					})
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"image"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// player is the tview front end that videos and animations share. It handles
+// the keys that change how frames look, follows the terminal size and draws
+// and records frames with their status lines. Keys are handled on tview's
+// goroutine and frames are rendered on the playback one, so everything both
+// of them touch, including the playback position, is only used with mu held.
+type player struct {
+	mu     sync.Mutex
+	app    *tview.Application
+	view   *cellView
+	status *tview.TextView
+	layout *tview.Flex
+	name   string
+	keys   string
+
+	// last is the text frame on screen and lastStatus the lines under it
+	last            *cellFrame
+	lastStatus      string
+	recordStart     time.Time
+	pendingGraphics []byte
+}
+
+// newPlayer sets up a player for the file name, keys is the legend of its
+// help line. onKey is called with mu held before the shared keys, for what
+// a, d, space and q do in this kind of media.
+func newPlayer(name, keys string, onKey func(key rune)) *player {
+	reservedRows = statusLines
+	p := &player{
+		app:         tview.NewApplication(),
+		view:        newCellView(),
+		status:      newStatusView(),
+		name:        name,
+		keys:        keys,
+		recordStart: time.Now(),
+	}
+	p.layout = playerLayout(p.view, p.status)
+
+	p.app.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		onKey(event.Rune())
+		switch event.Rune() {
+		case 'f':
+			skip--
+			if skip < 1 {
+				skip = 1
+			}
+		case 'r':
+			skip++
+			if skip > 10 {
+				skip = 10
+			}
+		case 'm':
+			cellModeIndex = (cellModeIndex + 1) % len(cellModes)
+		}
+		toneKey(event.Rune())
+		toggleFilter(event.Rune())
+		zoomKey(event)
+		return event
+	})
+	p.app.SetBeforeDrawFunc(func(screen tcell.Screen) bool {
+		cols, rows := screen.Size()
+		p.mu.Lock()
+		if (cols != screenCols || rows != screenRows) && screenCols != 0 {
+			resized = true
+		}
+		screenCols, screenRows = cols, rows
+		p.mu.Unlock()
+		return false
+	})
+	p.app.SetAfterDrawFunc(func(screen tcell.Screen) {
+		if p.pendingGraphics != nil {
+			os.Stdout.Write(p.pendingGraphics)
+			p.pendingGraphics = nil
+		}
+	})
+	return p
+}
+
+// run shows the player until it is stopped
+func (p *player) run() error {
+	return p.app.SetRoot(p.layout, true).Run()
+}
+
+// show renders img at position in the playback and queues the draw. picture
+// is the file img was decoded from, or nil.
+func (p *player) show(img image.Image, picture []byte, position string) {
+	p.mu.Lock()
+	if resized {
+		// the frame is rendered for the new size, even when paused
+		resized = false
+		p.last = nil
+		kitty.Reset()
+	}
+	text := statusText(position, "FileName: "+p.name, p.keys)
+	var draw func()
+	if outputMode != "ansi" {
+		// graphics are written straight to the terminal after tview has drawn
+		// the status lines, see SetAfterDrawFunc in newPlayer
+		seq := placeGraphics(renderGraphicsImage(img, picture))
+		draw = func() {
+			p.status.SetText(text)
+			p.pendingGraphics = seq
+		}
+	} else {
+		// colour noise below the threshold is dropped, so tcell only has to
+		// send the cells that really changed
+		frame := renderImage(img)
+		changed := frame.Stabilize(p.last, changeThreshold)
+		p.last = frame
+		if recorder != nil {
+			recorder.Frame(time.Since(p.recordStart), frame, text)
+		}
+		// tcell keeps what is on screen, an unchanged frame needs no draw
+		if changed > 0 || text != p.lastStatus {
+			draw = func() {
+				p.view.SetFrame(frame)
+				p.layout.ResizeItem(p.view, frame.Height, 0)
+				p.status.SetText(text)
+			}
+		}
+		p.lastStatus = text
+	}
+	p.mu.Unlock()
+
+	// tview's goroutine takes mu to draw, so the update is queued without it
+	if draw != nil {
+		p.app.QueueUpdateDraw(draw)
+	}
+}
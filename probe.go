@@ -0,0 +1,390 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// probeReport is what `why probe` finds out about a file, the JSON keys are
+// the ones -json prints
+type probeReport struct {
+	File      string            `json:"file"`
+	MediaType string            `json:"media_type,omitempty"`
+	Container string            `json:"container,omitempty"`
+	Size      int64             `json:"size"`
+	Duration  float64           `json:"duration,omitempty"`
+	BitRate   int64             `json:"bit_rate,omitempty"`
+	Streams   []probeStream     `json:"streams,omitempty"`
+	Tags      map[string]string `json:"tags,omitempty"`
+	Error     string            `json:"error,omitempty"`
+}
+
+type probeStream struct {
+	Index         int     `json:"index"`
+	Type          string  `json:"type"`
+	Codec         string  `json:"codec,omitempty"`
+	CodecLongName string  `json:"codec_long_name,omitempty"`
+	Width         int     `json:"width,omitempty"`
+	Height        int     `json:"height,omitempty"`
+	FrameRate     float64 `json:"frame_rate,omitempty"`
+	Frames        int     `json:"frames,omitempty"`
+	SampleRate    int     `json:"sample_rate,omitempty"`
+	Channels      int     `json:"channels,omitempty"`
+	BitRate       int64   `json:"bit_rate,omitempty"`
+}
+
+// probeHeadSize is how much of a file is read to sniff the container and the
+// tags, pictures are read whole
+const probeHeadSize = 64 * 1024
+
+// probeCommand runs `why probe [-json] FILE` and returns the exit code
+func probeCommand(args []string) int {
+	flags := flag.NewFlagSet("probe", flag.ExitOnError)
+	var jsonFlag = flags.Bool("json", false, "Print the report as JSON")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: why probe [-json] FILE")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	report, err := probe(flags.Arg(0))
+	if *jsonFlag {
+		if err != nil {
+			report.Error = err.Error()
+		}
+		out, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Println(string(out))
+	} else if err != nil {
+		fmt.Println("Unable to probe " + report.File + ": " + err.Error())
+	} else {
+		printProbe(report)
+	}
+	if err != nil {
+		return 1
+	}
+	return 0
+}
+
+// probe finds out what name is and how why would play it. Pictures and
+// animations are read with the same decoders that show them, everything else
+// goes through ffmpeg.
+func probe(name string) (*probeReport, error) {
+	report := &probeReport{File: name}
+	file, err := os.Open(name)
+	if err != nil {
+		return report, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return report, err
+	}
+	if info.IsDir() {
+		return report, fmt.Errorf("is a directory")
+	}
+	report.Size = info.Size()
+
+	data := make([]byte, probeHeadSize)
+	n, err := io.ReadFull(file, data)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return report, err
+	}
+	data = data[:n]
+	if isImage(data) && int64(n) < report.Size {
+		if data, err = os.ReadFile(name); err != nil {
+			return report, err
+		}
+	}
+
+	var anim *animation
+	report.MediaType, anim = mediaTypeOf(data)
+	report.Container = containerName(data)
+	report.Tags = id3Tags(data)
+	if report.Tags == nil {
+		report.Tags = pngTags(data)
+	}
+
+	switch report.MediaType {
+	case "animation":
+		stream := probeStream{
+			Type:   "video",
+			Codec:  report.Container,
+			Width:  anim.Bounds.Dx(),
+			Height: anim.Bounds.Dy(),
+			Frames: len(anim.Frames),
+		}
+		if stream.Codec == "png" {
+			stream.Codec = "apng"
+		}
+		report.Duration = anim.Duration().Seconds()
+		if report.Duration > 0 {
+			stream.FrameRate = float64(stream.Frames) / report.Duration
+		}
+		report.Streams = []probeStream{stream}
+	case "image":
+		config, format, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return report, err
+		}
+		report.Streams = []probeStream{{
+			Type:   "video",
+			Codec:  format,
+			Width:  config.Width,
+			Height: config.Height,
+			Frames: 1,
+		}}
+	default:
+		if err := probeMedia(name, report); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// containerName guesses the container from the first bytes of a file, ffmpeg
+// finds it the same way but gmf doesn't tell
+func containerName(head []byte) string {
+	has := func(offset int, magic string) bool {
+		return len(head) >= offset+len(magic) && string(head[offset:offset+len(magic)]) == magic
+	}
+	switch {
+	case has(0, "ID3"):
+		return "mp3"
+	case has(4, "ftyp"):
+		if has(8, "qt  ") {
+			return "mov"
+		}
+		return "mp4"
+	case has(0, "\x1a\x45\xdf\xa3"):
+		if bytes.Contains(head, []byte("webm")) {
+			return "webm"
+		}
+		return "matroska"
+	case has(0, "RIFF") && has(8, "AVI "):
+		return "avi"
+	case has(0, "RIFF") && has(8, "WAVE"):
+		return "wav"
+	case has(0, "OggS"):
+		return "ogg"
+	case has(0, "FLV"):
+		return "flv"
+	case has(0, "fLaC"):
+		return "flac"
+	case has(0, "\x00\x00\x01\xba"):
+		return "mpeg"
+	case len(head) > 188 && head[0] == 0x47 && head[188] == 0x47:
+		return "mpegts"
+	case isImage(head):
+		if _, format, err := image.DecodeConfig(bytes.NewReader(head)); err == nil {
+			return format
+		}
+	case len(head) >= 2 && head[0] == 0xFF && head[1]&0xE0 == 0xE0:
+		// an MPEG audio frame without a tag, the layer bits are 0 for AAC
+		if head[1]&0x06 == 0 {
+			return "aac"
+		}
+		return "mp3"
+	}
+	return ""
+}
+
+// id3Frames are the ID3v2 text frames that are reported, v2.2 has its own
+// three letter names
+var id3Frames = map[string]string{
+	"TIT2": "title", "TT2": "title",
+	"TPE1": "artist", "TP1": "artist",
+	"TALB": "album", "TAL": "album",
+	"TYER": "date", "TDRC": "date", "TYE": "date",
+	"TCON": "genre", "TCO": "genre",
+	"TRCK": "track", "TRK": "track",
+}
+
+// id3Tags reads the text frames of an ID3v2 tag at the start of data
+func id3Tags(data []byte) map[string]string {
+	if len(data) < 10 || string(data[:3]) != "ID3" {
+		return nil
+	}
+	version, flags := data[3], data[5]
+	size := int(syncsafe(data[6:10]))
+	data = data[10:]
+	if size < len(data) {
+		data = data[:size]
+	}
+	if flags&0x40 != 0 && len(data) >= 4 {
+		// an extended header, its size counts itself only in v2.4
+		ext := int(binary.BigEndian.Uint32(data)) + 4
+		if version == 4 {
+			ext = int(syncsafe(data[:4]))
+		}
+		if ext > len(data) {
+			return nil
+		}
+		data = data[ext:]
+	}
+
+	idSize, headerSize := 4, 10
+	if version == 2 {
+		idSize, headerSize = 3, 6
+	}
+	tags := map[string]string{}
+	for len(data) >= headerSize && data[0] != 0 {
+		id := string(data[:idSize])
+		var length int
+		switch version {
+		case 2:
+			length = int(data[3])<<16 | int(data[4])<<8 | int(data[5])
+		case 4:
+			length = int(syncsafe(data[4:8]))
+		default:
+			length = int(binary.BigEndian.Uint32(data[4:8]))
+		}
+		if length > len(data)-headerSize {
+			// cut off by probeHeadSize, cover art usually comes last
+			break
+		}
+		if key, ok := id3Frames[id]; ok && length > 0 {
+			if text := id3Text(data[headerSize : headerSize+length]); text != "" {
+				tags[key] = text
+			}
+		}
+		data = data[headerSize+length:]
+	}
+	if len(tags) == 0 {
+		return nil
+	}
+	return tags
+}
+
+// syncsafe reads a 28 bit number stored in the low 7 bits of 4 bytes
+func syncsafe(b []byte) uint32 {
+	return uint32(b[0]&0x7F)<<21 | uint32(b[1]&0x7F)<<14 | uint32(b[2]&0x7F)<<7 | uint32(b[3]&0x7F)
+}
+
+// id3Text decodes a text frame, the first byte is its encoding. Only the
+// first of several values is kept.
+func id3Text(b []byte) string {
+	encoding, b := b[0], b[1:]
+	var text string
+	switch encoding {
+	case 1, 2:
+		// UTF-16, with a byte order mark or big endian
+		order := binary.ByteOrder(binary.BigEndian)
+		if encoding == 1 && len(b) >= 2 {
+			if b[0] == 0xFF && b[1] == 0xFE {
+				order = binary.LittleEndian
+			}
+			b = b[2:]
+		}
+		units := make([]uint16, 0, len(b)/2)
+		for n := 0; n+1 < len(b); n += 2 {
+			units = append(units, order.Uint16(b[n:]))
+		}
+		text = string(utf16.Decode(units))
+	case 3:
+		text = string(b)
+	default:
+		// ISO-8859-1, which are the first 256 code points
+		runes := make([]rune, len(b))
+		for n, c := range b {
+			runes[n] = rune(c)
+		}
+		text = string(runes)
+	}
+	text, _, _ = strings.Cut(text, "\x00")
+	return strings.TrimSpace(text)
+}
+
+// pngTags reads the tEXt chunks of a PNG, such as Title, Author or Software
+func pngTags(data []byte) map[string]string {
+	chunks, err := readPNGChunks(data)
+	if err != nil {
+		return nil
+	}
+	tags := map[string]string{}
+	for _, chunk := range chunks {
+		if chunk.Type != "tEXt" {
+			continue
+		}
+		if key, value, ok := bytes.Cut(chunk.Data, []byte{0}); ok && len(key) > 0 {
+			// latin1 like in ID3
+			runes := make([]rune, len(value))
+			for n, c := range value {
+				runes[n] = rune(c)
+			}
+			tags[strings.ToLower(string(key))] = string(runes)
+		}
+	}
+	if len(tags) == 0 {
+		return nil
+	}
+	return tags
+}
+
+// printProbe writes report for people to read
+func printProbe(report *probeReport) {
+	line := func(label, value string) {
+		fmt.Printf("%-13s%s\n", label+":", value)
+	}
+	line("File", report.File)
+	line("Media type", report.MediaType)
+	if report.Container != "" {
+		line("Container", report.Container)
+	}
+	line("Size", fmt.Sprintf("%d bytes", report.Size))
+	if report.Duration > 0 {
+		d := time.Duration(report.Duration * float64(time.Second))
+		line("Duration", fmt.Sprintf("%s (%.3fs)", timestamp(d), report.Duration))
+	}
+	if report.BitRate > 0 {
+		line("Bit rate", fmt.Sprintf("%d kb/s", report.BitRate/1000))
+	}
+
+	for _, st := range report.Streams {
+		parts := []string{st.Type}
+		if st.Codec != "" {
+			parts = append(parts, st.Codec)
+		}
+		if st.Width > 0 {
+			parts = append(parts, fmt.Sprintf("%dx%d", st.Width, st.Height))
+		}
+		if st.FrameRate > 0 {
+			parts = append(parts, strings.TrimSuffix(strings.TrimRight(fmt.Sprintf("%.3f", st.FrameRate), "0"), ".")+" fps")
+		}
+		if st.Frames > 1 {
+			parts = append(parts, fmt.Sprintf("%d frames", st.Frames))
+		}
+		if st.SampleRate > 0 {
+			parts = append(parts, fmt.Sprintf("%d Hz", st.SampleRate))
+		}
+		if st.Channels > 0 {
+			parts = append(parts, fmt.Sprintf("%d channels", st.Channels))
+		}
+		if st.BitRate > 0 {
+			parts = append(parts, fmt.Sprintf("%d kb/s", st.BitRate/1000))
+		}
+		line(fmt.Sprintf("Stream %d", st.Index), strings.Join(parts, ", "))
+	}
+
+	keys := make([]string, 0, len(report.Tags))
+	for key := range report.Tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		line(strings.ToUpper(key[:1])+key[1:], report.Tags[key])
+	}
+}
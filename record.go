@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// castRecorder writes rendered frames to an asciinema asciicast v2 file. Only
+// the cells that changed since the frame before are written, the same way a
+// terminal would have received them.
+type castRecorder struct {
+	mu     sync.Mutex
+	closed bool
+	file   *os.File
+	out    *bufio.Writer
+	title  string
+	last   *cellFrame
+	status []string
+	width  int
+	height int
+}
+
+// recorder is set by -record
+var recorder *castRecorder
+
+func newCastRecorder(name, title string) (*castRecorder, error) {
+	file, err := os.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	return &castRecorder{file: file, out: bufio.NewWriter(file), title: title}, nil
+}
+
+// Frame records f with the status lines under it, at time at from the start
+// of the recording. Frames after Close are dropped.
+func (r *castRecorder) Frame(at time.Duration, f *cellFrame, status string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return
+	}
+	lines := strings.Split(status, "\n")
+	width, height := f.Width, f.Height+len(lines)
+	for _, line := range lines {
+		if n := utf8.RuneCountInString(line); n > width {
+			width = n
+		}
+	}
+
+	var data strings.Builder
+	if r.width == 0 {
+		// the header needs the size, so it is written with the first frame
+		header, _ := json.Marshal(map[string]interface{}{
+			"version":   2,
+			"width":     width,
+			"height":    height,
+			"timestamp": time.Now().Unix(),
+			"title":     r.title,
+		})
+		r.out.Write(header)
+		r.out.WriteByte('\n')
+		r.width, r.height = width, height
+	} else if width != r.width || height != r.height {
+		r.event(at, "r", strconv.Itoa(width)+"x"+strconv.Itoa(height))
+		r.width, r.height = width, height
+	}
+	if r.last == nil || r.last.Width != f.Width || r.last.Height != f.Height {
+		data.WriteString("\x1b[2J")
+		r.last, r.status = nil, nil
+	}
+
+	data.WriteString(f.DiffANSI(r.last, 1, 1))
+	for n, line := range lines {
+		if n < len(r.status) && r.status[n] == line {
+			continue
+		}
+		// centred under the frame like the player does
+		pad := (width - utf8.RuneCountInString(line)) / 2
+		data.WriteString("\x1b[" + strconv.Itoa(f.Height+n+1) + ";1H\x1b[2K")
+		data.WriteString(strings.Repeat(" ", pad) + line)
+	}
+	r.last, r.status = f, lines
+
+	if data.Len() > 0 {
+		r.event(at, "o", data.String())
+	}
+}
+
+func (r *castRecorder) event(at time.Duration, kind, data string) {
+	event, _ := json.Marshal([]interface{}{float64(at.Milliseconds()) / 1000, kind, data})
+	r.out.Write(event)
+	r.out.WriteByte('\n')
+}
+
+// Close writes what is buffered and closes the file, it can be called more
+// than once
+func (r *castRecorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	if err := r.out.Flush(); err != nil {
+		r.file.Close()
+		return err
+	}
+	return r.file.Close()
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"image"
+	"image/draw"
+	"math"
+)
+
+// resampleFilter is how pictures are scaled down before they are turned into
+// cells: "point" reads single pixels like the renderer always did, "box"
+// averages the area under each pixel, "bilinear" and "lanczos" use smoother
+// kernels. All but point work in linear light.
+var resampleFilter = "box"
+
+type resampleKernel struct {
+	Support float64
+	Weight  func(x float64) float64
+}
+
+var resampleKernels = map[string]resampleKernel{
+	"box": {0.5, func(x float64) float64 {
+		if x >= -0.5 && x < 0.5 {
+			return 1
+		}
+		return 0
+	}},
+	"bilinear": {1, func(x float64) float64 {
+		x = math.Abs(x)
+		if x < 1 {
+			return 1 - x
+		}
+		return 0
+	}},
+	"lanczos": {3, func(x float64) float64 {
+		if x == 0 {
+			return 1
+		}
+		if x <= -3 || x >= 3 {
+			return 0
+		}
+		px := math.Pi * x
+		return 3 * math.Sin(px) * math.Sin(px/3) / (px * px)
+	}},
+}
+
+// toLinear maps sRGB bytes to linear light, fromLinear goes back with 4096
+// steps which is plenty for 8 bit output
+var toLinear [256]float32
+var fromLinear [4096]uint8
+
+func init() {
+	for n := range toLinear {
+		v := float64(n) / 255
+		if v <= 0.04045 {
+			v = v / 12.92
+		} else {
+			v = math.Pow((v+0.055)/1.055, 2.4)
+		}
+		toLinear[n] = float32(v)
+	}
+	for n := range fromLinear {
+		v := float64(n) / float64(len(fromLinear)-1)
+		if v <= 0.0031308 {
+			v = v * 12.92
+		} else {
+			v = 1.055*math.Pow(v, 1/2.4) - 0.055
+		}
+		fromLinear[n] = uint8(math.Round(v * 255))
+	}
+}
+
+func linearToByte(v float32) uint8 {
+	if v <= 0 {
+		return 0
+	}
+	if v >= 1 {
+		return 255
+	}
+	return fromLinear[int(v*float32(len(fromLinear)-1)+0.5)]
+}
+
+func validResampleFilter(name string) bool {
+	_, ok := resampleKernels[name]
+	return ok || name == "point"
+}
+
+// resampleWeights holds the source pixels and their weights for one output
+// pixel along one axis
+type resampleWeights struct {
+	Start   int
+	Weights []float32
+}
+
+func makeWeights(src, dst int, kernel resampleKernel) []resampleWeights {
+	scale := float64(src) / float64(dst)
+	// when shrinking, the kernel is stretched over all source pixels that fall
+	// inside one output pixel
+	stretch := math.Max(scale, 1)
+	radius := kernel.Support * stretch
+
+	out := make([]resampleWeights, dst)
+	for n := range out {
+		center := (float64(n)+0.5)*scale - 0.5
+		lo := int(math.Ceil(center - radius))
+		hi := int(math.Floor(center + radius))
+		if lo < 0 {
+			lo = 0
+		}
+		if hi > src-1 {
+			hi = src - 1
+		}
+
+		weights := make([]float32, 0, hi-lo+1)
+		sum := 0.0
+		for j := lo; j <= hi; j++ {
+			w := kernel.Weight((float64(j) - center) / stretch)
+			weights = append(weights, float32(w))
+			sum += w
+		}
+		if sum == 0 {
+			// nothing under the kernel, fall back to the closest pixel
+			nearest := int(math.Round(center))
+			if nearest < 0 {
+				nearest = 0
+			}
+			if nearest > src-1 {
+				nearest = src - 1
+			}
+			out[n] = resampleWeights{Start: nearest, Weights: []float32{1}}
+			continue
+		}
+		for j := range weights {
+			weights[j] /= float32(sum)
+		}
+		out[n] = resampleWeights{Start: lo, Weights: weights}
+	}
+	return out
+}
+
+// toRGBA returns img as an *image.RGBA with its origin at 0, 0, converting
+// it if needed
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok && rgba.Rect.Min == (image.Point{}) {
+		return rgba
+	}
+	rgba := image.NewRGBA(image.Rect(0, 0, img.Bounds().Dx(), img.Bounds().Dy()))
+	draw.Draw(rgba, rgba.Rect, img, img.Bounds().Min, draw.Src)
+	return rgba
+}
+
+// linearPixel turns one premultiplied sRGB pixel into premultiplied linear
+// light, so transparent pixels don't bleed their colour into their neighbours
+func linearPixel(pix []uint8) (r, g, b, a float32) {
+	switch pix[3] {
+	case 0:
+		return 0, 0, 0, 0
+	case 0xFF:
+		return toLinear[pix[0]], toLinear[pix[1]], toLinear[pix[2]], 1
+	}
+	alpha := int(pix[3])
+	a = float32(alpha) / 255
+	r = toLinear[clampByte(int(pix[0])*255/alpha)] * a
+	g = toLinear[clampByte(int(pix[1])*255/alpha)] * a
+	b = toLinear[clampByte(int(pix[2])*255/alpha)] * a
+	return r, g, b, a
+}
+
+// resize scales img to w by h pixels with the selected filter
+func resize(img image.Image, w, h int) *image.RGBA {
+	kernel, ok := resampleKernels[resampleFilter]
+	if !ok {
+		return resizeNearest(img, w, h)
+	}
+	src := toRGBA(img)
+	sw, sh := src.Rect.Dx(), src.Rect.Dy()
+
+	// horizontal pass into linear light rows, four channels per pixel
+	xWeights := makeWeights(sw, w, kernel)
+	tmp := make([]float32, 4*w*sh)
+	forEachBand(sh, func(_ int, band rowBand) {
+		for y := band.Lo; y < band.Hi; y++ {
+			row := src.Pix[y*src.Stride:]
+			for x, xw := range xWeights {
+				var r, g, b, a float32
+				for n, weight := range xw.Weights {
+					off := 4 * (xw.Start + n)
+					pr, pg, pb, pa := linearPixel(row[off : off+4])
+					r += pr * weight
+					g += pg * weight
+					b += pb * weight
+					a += pa * weight
+				}
+				t := 4 * (y*w + x)
+				tmp[t], tmp[t+1], tmp[t+2], tmp[t+3] = r, g, b, a
+			}
+		}
+	})
+
+	// vertical pass, back to premultiplied sRGB
+	yWeights := makeWeights(sh, h, kernel)
+	out := image.NewRGBA(image.Rect(0, 0, w, h))
+	forEachBand(h, func(_ int, band rowBand) {
+		for y := band.Lo; y < band.Hi; y++ {
+			yw := yWeights[y]
+			for x := 0; x < w; x++ {
+				var r, g, b, a float32
+				for n, weight := range yw.Weights {
+					t := 4 * ((yw.Start+n)*w + x)
+					r += tmp[t] * weight
+					g += tmp[t+1] * weight
+					b += tmp[t+2] * weight
+					a += tmp[t+3] * weight
+				}
+				off := out.PixOffset(x, y)
+				alpha := linearAlpha(a)
+				if alpha == 0 {
+					continue
+				}
+				out.Pix[off] = premultiply(linearToByte(r/a), alpha)
+				out.Pix[off+1] = premultiply(linearToByte(g/a), alpha)
+				out.Pix[off+2] = premultiply(linearToByte(b/a), alpha)
+				out.Pix[off+3] = alpha
+			}
+		}
+	})
+	return out
+}
+
+// linearAlpha converts a filtered alpha back to a byte, alpha is not gamma
+// encoded
+func linearAlpha(a float32) uint8 {
+	if a <= 0 {
+		return 0
+	}
+	if a >= 1 {
+		return 0xFF
+	}
+	return uint8(a*255 + 0.5)
+}
+
+func premultiply(c, alpha uint8) uint8 {
+	if alpha == 0xFF {
+		return c
+	}
+	return uint8(int(c) * int(alpha) / 255)
+}
+
+// shrink scales img down the way -scale does, every skip+1 pixels become one
+func shrink(img image.Image, skip int) *image.RGBA {
+	w := (img.Bounds().Dx() + skip) / (skip + 1)
+	h := (img.Bounds().Dy() + skip) / (skip + 1)
+	return resize(img, w, h)
+}
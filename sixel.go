@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"strconv"
+)
+
+// sixel colour registers hold the xterm-256 cube and greyscale ramp, register
+// n is palette entry n+16
+const sixelColors = 240
+
+// sixelTransparent marks pixels that are left out of the image
+const sixelTransparent = 0xFF
+
+func sixelQuantize(c color.RGBA) color.RGBA {
+	_, q := nearest256(c)
+	return q
+}
+
+// encodeSixel encodes img as a DEC sixel image. Pixels are reduced to the
+// 240 colour xterm palette, dithered if a dither mode is selected.
+func encodeSixel(img *image.RGBA) []byte {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	if ditherMode != "none" {
+		ditherImage(img, sixelQuantize, 48)
+	}
+
+	index := make([]uint8, w*h)
+	var used [sixelColors]bool
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			off := img.PixOffset(x, y)
+			if img.Pix[off+3] == 0 {
+				// never drawn, the terminal background shows through
+				index[y*w+x] = sixelTransparent
+				continue
+			}
+			n, _ := nearest256(color.RGBA{img.Pix[off], img.Pix[off+1], img.Pix[off+2], 0xFF})
+			index[y*w+x] = uint8(n - 16)
+			used[n-16] = true
+		}
+	}
+
+	var out bytes.Buffer
+	// P2=1 leaves pixels that are not set untouched, "1;1 keeps a 1:1 aspect
+	out.WriteString("\x1bP0;1;0q\"1;1;")
+	out.WriteString(strconv.Itoa(w))
+	out.WriteByte(';')
+	out.WriteString(strconv.Itoa(h))
+
+	for n := 0; n < sixelColors; n++ {
+		if !used[n] {
+			continue
+		}
+		c := paletteColor(n + 16)
+		out.WriteByte('#')
+		out.WriteString(strconv.Itoa(n))
+		out.WriteString(";2;")
+		out.WriteString(strconv.Itoa(int(c.R) * 100 / 255))
+		out.WriteByte(';')
+		out.WriteString(strconv.Itoa(int(c.G) * 100 / 255))
+		out.WriteByte(';')
+		out.WriteString(strconv.Itoa(int(c.B) * 100 / 255))
+	}
+
+	row := make([]byte, w)
+	for y0 := 0; y0 < h; y0 += 6 {
+		var present [sixelColors]bool
+		for y := y0; y < y0+6 && y < h; y++ {
+			for _, n := range index[y*w : (y+1)*w] {
+				if n != sixelTransparent {
+					present[n] = true
+				}
+			}
+		}
+
+		first := true
+		for n := 0; n < sixelColors; n++ {
+			if !present[n] {
+				continue
+			}
+			last := -1
+			for x := 0; x < w; x++ {
+				bits := byte(0)
+				for dy := 0; dy < 6 && y0+dy < h; dy++ {
+					if index[(y0+dy)*w+x] == uint8(n) {
+						bits |= 1 << dy
+					}
+				}
+				row[x] = '?' + bits
+				if bits != 0 {
+					last = x
+				}
+			}
+
+			if !first {
+				// carriage return, the next colour overprints the same band
+				out.WriteByte('$')
+			}
+			first = false
+			out.WriteByte('#')
+			out.WriteString(strconv.Itoa(n))
+			writeSixelRuns(&out, row[:last+1])
+		}
+		out.WriteByte('-')
+	}
+
+	out.WriteString("\x1b\\")
+	return out.Bytes()
+}
+
+// writeSixelRuns writes a row of sixels, collapsing repeats with the !count
+// introducer where it is shorter
+func writeSixelRuns(out *bytes.Buffer, row []byte) {
+	for x := 0; x < len(row); {
+		run := 1
+		for x+run < len(row) && row[x+run] == row[x] {
+			run++
+		}
+		if run > 3 {
+			out.WriteByte('!')
+			out.WriteString(strconv.Itoa(run))
+			out.WriteByte(row[x])
+		} else {
+			for n := 0; n < run; n++ {
+				out.WriteByte(row[x])
+			}
+		}
+		x += run
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+// testImage is a colour gradient with some noise on it, so neighbouring pixels
+// are alike but not always the same, like in a video
+func testImage(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	rng := rand.New(rand.NewSource(1))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			off := img.PixOffset(x, y)
+			noise := rng.Intn(24)
+			img.Pix[off] = uint8((x*255/w + noise) & 0xFF)
+			img.Pix[off+1] = uint8((y*255/h + noise) & 0xFF)
+			img.Pix[off+2] = uint8(((x+y)*255/(w+h) + noise) & 0xFF)
+			img.Pix[off+3] = 0xFF
+		}
+	}
+	return img
+}
+
+// decodedSixel is what decodeSixel read from a stream: the size from the
+// raster attributes, the colour registers and the register every pixel was
+// painted with, -1 where nothing was painted
+type decodedSixel struct {
+	Width, Height int
+	Registers     map[int]color.RGBA
+	Pixels        []int
+}
+
+// decodeSixel parses the subset of sixel that encodeSixel writes
+func decodeSixel(data []byte) (*decodedSixel, error) {
+	start := bytes.IndexByte(data, 'q')
+	if !bytes.HasPrefix(data, []byte("\x1bP")) || start < 0 || !bytes.HasSuffix(data, []byte("\x1b\\")) {
+		return nil, fmt.Errorf("not a sixel stream")
+	}
+	data = data[start+1 : len(data)-2]
+	pos := 0
+	// number reads the decimal number at pos, the parameters are separated by ;
+	number := func() int {
+		end := pos
+		for end < len(data) && data[end] >= '0' && data[end] <= '9' {
+			end++
+		}
+		n, _ := strconv.Atoi(string(data[pos:end]))
+		pos = end
+		return n
+	}
+	params := func() []int {
+		values := []int{number()}
+		for pos < len(data) && data[pos] == ';' {
+			pos++
+			values = append(values, number())
+		}
+		return values
+	}
+
+	d := &decodedSixel{Registers: map[int]color.RGBA{}}
+	if pos >= len(data) || data[pos] != '"' {
+		return nil, fmt.Errorf("no raster attributes")
+	}
+	pos++
+	raster := params()
+	if len(raster) != 4 {
+		return nil, fmt.Errorf("raster attributes %v", raster)
+	}
+	d.Width, d.Height = raster[2], raster[3]
+	d.Pixels = make([]int, d.Width*d.Height)
+	for n := range d.Pixels {
+		d.Pixels[n] = -1
+	}
+
+	x, band, register := 0, 0, -1
+	paint := func(sixel byte, count int) error {
+		if register < 0 {
+			return fmt.Errorf("sixel before a colour was selected")
+		}
+		for ; count > 0; count-- {
+			for dy := 0; dy < 6; dy++ {
+				if (sixel-'?')&(1<<dy) == 0 {
+					continue
+				}
+				y := band*6 + dy
+				if x >= d.Width || y >= d.Height {
+					return fmt.Errorf("pixel %d,%d outside of the raster", x, y)
+				}
+				d.Pixels[y*d.Width+x] = register
+			}
+			x++
+		}
+		return nil
+	}
+	for pos < len(data) {
+		switch ch := data[pos]; {
+		case ch == '#':
+			pos++
+			values := params()
+			if len(values) == 5 {
+				if values[1] != 2 {
+					return nil, fmt.Errorf("colour space %d", values[1])
+				}
+				d.Registers[values[0]] = color.RGBA{uint8(values[2] * 255 / 100), uint8(values[3] * 255 / 100), uint8(values[4] * 255 / 100), 0xFF}
+			} else if _, ok := d.Registers[values[0]]; !ok {
+				return nil, fmt.Errorf("register %d used before it was defined", values[0])
+			}
+			register = values[0]
+		case ch == '!':
+			pos++
+			count := number()
+			if pos >= len(data) || data[pos] < '?' || data[pos] > '~' {
+				return nil, fmt.Errorf("repeat without a sixel")
+			}
+			if err := paint(data[pos], count); err != nil {
+				return nil, err
+			}
+			pos++
+		case ch == '$':
+			x = 0
+			pos++
+		case ch == '-':
+			x = 0
+			band++
+			pos++
+		case ch >= '?' && ch <= '~':
+			if err := paint(ch, 1); err != nil {
+				return nil, err
+			}
+			pos++
+		default:
+			return nil, fmt.Errorf("unexpected %q at %d", ch, pos)
+		}
+	}
+	return d, nil
+}
+
+func TestEncodeSixel(t *testing.T) {
+	solid := image.NewRGBA(image.Rect(0, 0, 16, 12))
+	for n := range solid.Pix {
+		solid.Pix[n] = []uint8{0x20, 0x90, 0xE0, 0xFF}[n%4]
+	}
+	// holes are left to the terminal background
+	holes := testImage(10, 10)
+	for y := 0; y < 10; y++ {
+		holes.SetRGBA(y, y, color.RGBA{})
+		holes.SetRGBA(9-y, y, color.RGBA{})
+	}
+	images := map[string]*image.RGBA{
+		"solid":    solid,
+		"gradient": testImage(64, 48),
+		"odd":      testImage(7, 13),
+		"row":      testImage(33, 1),
+		"holes":    holes,
+	}
+
+	for name, img := range images {
+		t.Run(name, func(t *testing.T) {
+			d, err := decodeSixel(encodeSixel(img))
+			if err != nil {
+				t.Fatal(err)
+			}
+			w, h := img.Rect.Dx(), img.Rect.Dy()
+			if d.Width != w || d.Height != h {
+				t.Fatalf("raster is %dx%d, want %dx%d", d.Width, d.Height, w, h)
+			}
+			for y := 0; y < h; y++ {
+				for x := 0; x < w; x++ {
+					c := img.RGBAAt(x, y)
+					got := d.Pixels[y*w+x]
+					if c.A == 0 {
+						if got != -1 {
+							t.Fatalf("transparent pixel %d,%d painted with %d", x, y, got)
+						}
+						continue
+					}
+					n, q := nearest256(c)
+					if got != n-16 {
+						t.Fatalf("pixel %d,%d is register %d, want %d", x, y, got, n-16)
+					}
+					// the registers are in percent, which loses a little
+					r := d.Registers[got]
+					if !near(r.R, q.R) || !near(r.G, q.G) || !near(r.B, q.B) {
+						t.Fatalf("register %d is %v, want %v", got, r, q)
+					}
+				}
+			}
+		})
+	}
+}
+
+// near reports whether two channels are within the rounding of a percentage
+func near(a, b uint8) bool {
+	return int(a)-int(b) <= 3 && int(b)-int(a) <= 3
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"image"
+	"image/color"
+)
+
+// cellMode describes how many source pixels are packed into a single terminal
+// cell, and which glyph is drawn for a given on/off pattern of those pixels.
+// Pixels are numbered row-major inside the cell, bit n of the mask is pixel n.
+type cellMode struct {
+	Name   string
+	Width  int
+	Height int
+	Glyph  func(mask int) string
+}
+
+var quadrantGlyphs = []string{" ", "▘", "▝", "▀", "▖", "▌", "▞", "▛", "▗", "▚", "▐", "▜", "▄", "▙", "▟", "█"}
+
+// braille dots are numbered column first, with the bottom row added later on
+var brailleBits = []int{0x01, 0x08, 0x02, 0x10, 0x04, 0x20, 0x40, 0x80}
+
+var cellModes = []cellMode{
+	{Name: "half", Width: 1, Height: 2, Glyph: func(mask int) string {
+		return []string{" ", UPPER_HALF_BLOCK, "▄", "█"}[mask]
+	}},
+	{Name: "quadrant", Width: 2, Height: 2, Glyph: func(mask int) string {
+		return quadrantGlyphs[mask]
+	}},
+	{Name: "sextant", Width: 2, Height: 3, Glyph: sextantGlyph},
+	{Name: "braille", Width: 2, Height: 4, Glyph: func(mask int) string {
+		r := 0x2800
+		for n, bit := range brailleBits {
+			if mask&(1<<n) != 0 {
+				r += bit
+			}
+		}
+		return string(rune(r))
+	}},
+	// ascii cells are picked by brightness, see convertImageToASCII
+	{Name: "ascii", Width: 1, Height: 2, Glyph: func(mask int) string {
+		return string(asciiRamp[len(asciiRamp)-1])
+	}},
+}
+
+var cellModeIndex int
+
+// sextantGlyph maps a 2x3 mask onto the Unicode 13 "Symbols for Legacy
+// Computing" sextants. The block skips the patterns that already exist as
+// space, left half, right half and full block.
+func sextantGlyph(mask int) string {
+	switch mask {
+	case 0:
+		return " "
+	case 21:
+		return "▌"
+	case 42:
+		return "▐"
+	case 63:
+		return "█"
+	}
+	r := 0x1FB00 + mask - 1
+	if mask > 21 {
+		r--
+	}
+	if mask > 42 {
+		r--
+	}
+	return string(rune(r))
+}
+
+func findCellMode(name string) (int, bool) {
+	for n, m := range cellModes {
+		if m.Name == name {
+			return n, true
+		}
+	}
+	return 0, false
+}
+
+func colorDistance(a, b color.RGBA) int {
+	dr := int(a.R) - int(b.R)
+	dg := int(a.G) - int(b.G)
+	db := int(a.B) - int(b.B)
+	return dr*dr + dg*dg + db*db
+}
+
+func averageColor(pix []color.RGBA, mask int, set bool) color.RGBA {
+	var r, g, b, n int
+	for idx, c := range pix {
+		if (mask&(1<<idx) != 0) != set {
+			continue
+		}
+		r += int(c.R)
+		g += int(c.G)
+		b += int(c.B)
+		n++
+	}
+	if n == 0 {
+		return color.RGBA{A: 0xFF}
+	}
+	return color.RGBA{R: uint8(r / n), G: uint8(g / n), B: uint8(b / n), A: 0xFF}
+}
+
+// splitCell picks the two colours that best represent the pixels of a cell.
+// The two most distant pixels seed the groups, every pixel joins the closer
+// one, and the group averages are refined once more. Set bits in the returned
+// mask are drawn with the foreground colour.
+func splitCell(pix []color.RGBA) (mask int, fg, bg color.RGBA) {
+	var a, b color.RGBA
+	far := -1
+	for n := range pix {
+		for m := n + 1; m < len(pix); m++ {
+			if d := colorDistance(pix[n], pix[m]); d > far {
+				far = d
+				a, b = pix[n], pix[m]
+			}
+		}
+	}
+	if far <= 0 {
+		c := averageColor(pix, 0, false)
+		return 0, c, c
+	}
+
+	for pass := 0; pass < 2; pass++ {
+		mask = 0
+		for n, c := range pix {
+			if colorDistance(c, a) < colorDistance(c, b) {
+				mask |= 1 << n
+			}
+		}
+		if mask == 0 || mask == 1<<len(pix)-1 {
+			c := averageColor(pix, 0, false)
+			return 0, c, c
+		}
+		a = averageColor(pix, mask, true)
+		b = averageColor(pix, mask, false)
+	}
+	return mask, a, b
+}
+
+// subcellAt samples the pixels of the cell whose top left pixel is x, y
+// and picks its glyph and colours. pix is scratch space for the samples.
+func subcellAt(img image.Image, x, y, skip int, mode cellMode, pix []color.RGBA) cell {
+	bounds := img.Bounds()
+	for dy := 0; dy < mode.Height; dy++ {
+		for dx := 0; dx < mode.Width; dx++ {
+			// pixels past the edge repeat the last row / column
+			px := x + dx*skip
+			if px >= bounds.Max.X {
+				px = bounds.Max.X - 1
+			}
+			py := y + dy*skip
+			if py >= bounds.Max.Y {
+				py = bounds.Max.Y - 1
+			}
+			c := pixelAt(img, px, py)
+			c.A = opaque(c.A)
+			pix[dy*mode.Width+dx] = c
+		}
+	}
+
+	// with transparent pixels the split is already made, the background is
+	// left to the terminal
+	visible := 0
+	for n, c := range pix {
+		if c.A != 0 {
+			visible |= 1 << n
+		}
+	}
+	if visible == 0 {
+		return cell{Glyph: " "}
+	}
+	if visible != 1<<len(pix)-1 {
+		return cell{Glyph: mode.Glyph(visible), Fg: averageColor(pix, visible, true)}
+	}
+
+	mask, fg, bg := splitCell(pix)
+	return cell{Glyph: mode.Glyph(mask), Fg: fg, Bg: bg}
+}
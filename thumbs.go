@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"math"
+	"os"
+	"time"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// thumbCount is how many frames -thumbs shows instead of playing the video
+var thumbCount int
+
+// thumbSheet is the PNG file the contact sheet is written to, empty shows it
+// in the terminal
+var thumbSheet string
+
+// thumbWidth is the width of a tile in pixels when the sheet is a picture
+const thumbWidth = 320
+
+// thumbGap is the space between the tiles in cells, thumbPixelGap in pixels
+const thumbGap = 2
+const thumbPixelGap = 8
+
+// thumbGrid lays n tiles out in a grid that is about as wide as it is tall
+func thumbGrid(n int) (int, int) {
+	cols := int(math.Ceil(math.Sqrt(float64(n))))
+	return cols, (n + cols - 1) / cols
+}
+
+// timestamp writes d as m:ss, or h:mm:ss for long videos
+func timestamp(d time.Duration) string {
+	s := int(d / time.Second)
+	if s >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", s/3600, s/60%60, s%60)
+	}
+	return fmt.Sprintf("%d:%02d", s/60, s%60)
+}
+
+// contactSheet shows thumbCount frames from all through the video in a grid,
+// with the time of each under it. In the terminal the grid fills its width,
+// each tile is rendered like a picture at -scale.
+func contactSheet(name string) error {
+	cols, _ := thumbGrid(thumbCount)
+	if thumbSheet != "" || outputMode != "ansi" {
+		images, times, err := sampleFrames(name, thumbCount, thumbWidth)
+		if err != nil {
+			return err
+		}
+		sheet := drawContactSheet(images, times, cols)
+		if thumbSheet == "" {
+			seq, _, _ := renderGraphicsImage(sheet, nil)
+			os.Stdout.Write(seq)
+			fmt.Println()
+			return nil
+		}
+		file, err := os.Create(thumbSheet)
+		if err != nil {
+			return err
+		}
+		if err := png.Encode(file, sheet); err != nil {
+			file.Close()
+			return err
+		}
+		return file.Close()
+	}
+
+	termCols, _ := terminalSize()
+	tileCols := (termCols - thumbGap*(cols-1)) / cols
+	if tileCols < 4 {
+		tileCols = 4
+	}
+	if skip == 0 {
+		skip = 7
+	}
+	// the frames are decoded at the size -scale shrinks to the tile width
+	images, times, err := sampleFrames(name, thumbCount, tileCols*cellModes[cellModeIndex].Width*(skip+1))
+	if err != nil {
+		return err
+	}
+	tiles := make([]*cellFrame, len(images))
+	for n, img := range images {
+		tiles[n] = renderImage(img)
+	}
+	fmt.Print(tileFrames(tiles, times, cols).ANSI())
+	return nil
+}
+
+// tileFrames puts frames of the same size into a grid of cells, with the
+// timestamps as text under them
+func tileFrames(frames []*cellFrame, times []time.Duration, cols int) *cellFrame {
+	tileW, tileH := frames[0].Width, frames[0].Height
+	rows := (len(frames) + cols - 1) / cols
+	// a row of text and a blank row under every tile, but the last
+	sheet := newCellFrame(cols*tileW+(cols-1)*thumbGap, rows*(tileH+2)-1)
+	for n := range sheet.Cells {
+		sheet.Cells[n].Glyph = " "
+	}
+	for n, f := range frames {
+		x0, y0 := n%cols*(tileW+thumbGap), n/cols*(tileH+2)
+		for y := 0; y < f.Height && y < tileH; y++ {
+			for x := 0; x < f.Width && x < tileW; x++ {
+				*sheet.At(x0+x, y0+y) = *f.At(x, y)
+			}
+		}
+		label := []rune(timestamp(times[n]))
+		left := x0 + (tileW-len(label))/2
+		for i, r := range label {
+			if x := left + i; x >= x0 && x < x0+tileW {
+				sheet.At(x, y0+tileH).Glyph = string(r)
+			}
+		}
+	}
+	return sheet
+}
+
+// drawContactSheet puts the frames into a grid of pixels with the timestamps
+// under them, for -sheet and the graphics outputs
+func drawContactSheet(images []*image.RGBA, times []time.Duration, cols int) *image.RGBA {
+	face := basicfont.Face7x13
+	label := face.Metrics().Height.Ceil() + thumbPixelGap/2
+	tileW, tileH := images[0].Rect.Dx(), images[0].Rect.Dy()
+	rows := (len(images) + cols - 1) / cols
+	sheet := image.NewRGBA(image.Rect(0, 0, cols*(tileW+thumbPixelGap)+thumbPixelGap, rows*(tileH+label+thumbPixelGap)+thumbPixelGap))
+	draw.Draw(sheet, sheet.Rect, &image.Uniform{exportBackground}, image.Point{}, draw.Src)
+
+	for n, img := range images {
+		x0 := thumbPixelGap + n%cols*(tileW+thumbPixelGap)
+		y0 := thumbPixelGap + n/cols*(tileH+label+thumbPixelGap)
+		draw.Draw(sheet, image.Rect(x0, y0, x0+tileW, y0+tileH), img, img.Rect.Min, draw.Over)
+		text := timestamp(times[n])
+		d := font.Drawer{
+			Dst:  sheet,
+			Src:  &image.Uniform{exportForeground},
+			Face: face,
+		}
+		width := d.MeasureString(text).Ceil()
+		d.Dot = fixed.P(x0+(tileW-width)/2, y0+tileH+thumbPixelGap/2+face.Metrics().Ascent.Ceil())
+		d.DrawString(text)
+	}
+	return sheet
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+)
+
+// tone adjustments applied to every frame before it is turned into cells.
+// brightness is added (-1 to 1), contrast stretches around mid grey, gamma
+// above 1 lifts the shadows and saturation 0 is greyscale.
+var brightness = 0.0
+var contrast = 1.0
+var gamma = 1.0
+var saturation = 1.0
+
+// toneStep is how much a key press changes a tone setting
+const toneStep = 0.1
+
+func toneActive() bool {
+	return brightness != 0 || contrast != 1 || gamma != 1 || saturation != 1
+}
+
+// toneStatus shows the tone settings in the status lines, empty when they
+// are all at their defaults
+func toneStatus() string {
+	if !toneActive() {
+		return ""
+	}
+	return fmt.Sprintf("  bright %+.1f  contrast %.1f  gamma %.1f  sat %.1f", brightness, contrast, gamma, saturation)
+}
+
+// clampTone keeps the tone settings in a range where they still make sense
+func clampTone() {
+	brightness = math.Max(-1, math.Min(1, brightness))
+	contrast = math.Max(0, math.Min(4, contrast))
+	gamma = math.Max(0.1, math.Min(4, gamma))
+	saturation = math.Max(0, math.Min(4, saturation))
+}
+
+// toneKey changes the tone settings for b c g s (down) and B C G S (up), 0
+// resets them. It reports whether the key was one of them.
+func toneKey(key rune) bool {
+	switch key {
+	case 'b':
+		brightness -= toneStep
+	case 'B':
+		brightness += toneStep
+	case 'c':
+		contrast -= toneStep
+	case 'C':
+		contrast += toneStep
+	case 'g':
+		gamma -= toneStep
+	case 'G':
+		gamma += toneStep
+	case 's':
+		saturation -= toneStep
+	case 'S':
+		saturation += toneStep
+	case '0':
+		brightness, contrast, gamma, saturation = 0, 1, 1, 1
+	default:
+		return false
+	}
+	// repeated steps of 0.1 drift, keep the values on the steps
+	brightness = math.Round(brightness*10) / 10
+	contrast = math.Round(contrast*10) / 10
+	gamma = math.Round(gamma*10) / 10
+	saturation = math.Round(saturation*10) / 10
+	clampTone()
+	return true
+}
+
+// adjustTone applies the tone settings to img in place
+func adjustTone(img *image.RGBA) {
+	if !toneActive() {
+		return
+	}
+	// gamma, contrast and brightness work per channel, a table covers them
+	var curve [256]uint8
+	for n := range curve {
+		v := math.Pow(float64(n)/255, 1/gamma)
+		v = (v-0.5)*contrast + 0.5 + brightness
+		curve[n] = clampByte(int(math.Round(v * 255)))
+	}
+
+	mapPixels(img, func(r, g, b int) (int, int, int) {
+		r, g, b = int(curve[r]), int(curve[g]), int(curve[b])
+		if saturation != 1 {
+			l := luminance(uint8(r), uint8(g), uint8(b))
+			r = l + int(float64(r-l)*saturation)
+			g = l + int(float64(g-l)*saturation)
+			b = l + int(float64(b-l)*saturation)
+		}
+		return r, g, b
+	})
+}
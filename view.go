@@ -0,0 +1,72 @@
+package main
+
+import (
+	"image/color"
+	"unicode/utf8"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// cellView is a tview primitive that draws a cellFrame straight into the
+// screen's cells. tcell only sends the cells that changed since the last
+// draw, so there is no ANSI text to build or parse during playback.
+type cellView struct {
+	*tview.Box
+	frame *cellFrame
+}
+
+func newCellView() *cellView {
+	v := &cellView{Box: tview.NewBox()}
+	v.SetBackgroundColor(tcell.ColorDefault)
+	return v
+}
+
+// SetFrame replaces the frame that is drawn, it has to be called from the
+// application's goroutine, e.g. inside QueueUpdateDraw
+func (v *cellView) SetFrame(f *cellFrame) {
+	v.frame = f
+}
+
+func (v *cellView) Draw(screen tcell.Screen) {
+	v.DrawForSubclass(screen, v)
+	if v.frame == nil {
+		return
+	}
+	x0, y0, w, h := v.GetInnerRect()
+	if v.frame.Width < w {
+		// centred, like the status lines under it
+		x0 += (w - v.frame.Width) / 2
+	}
+	for y := 0; y < v.frame.Height && y < h; y++ {
+		for x := 0; x < v.frame.Width && x < w; x++ {
+			c := v.frame.At(x, y)
+			fg, bg := tcellColor(c.Fg), tcellColor(c.Bg)
+			glyph, _ := utf8.DecodeRuneInString(c.Glyph)
+			if fg == bg && bg != tcell.ColorDefault {
+				// the glyph can't be seen on its own colour, as a space the cell
+				// stays equal to the last draw whichever glyph the mode picked,
+				// so tcell doesn't send it again
+				glyph = ' '
+			}
+			screen.SetContent(x0+x, y0+y, glyph, nil, tcell.StyleDefault.Foreground(fg).Background(bg))
+		}
+	}
+}
+
+// tcellColor picks the colour a cell is drawn with at the selected colour
+// depth, zero alpha is the terminal default
+func tcellColor(c color.RGBA) tcell.Color {
+	if c.A == 0 {
+		return tcell.ColorDefault
+	}
+	switch colorDepth {
+	case "256":
+		n, _ := nearest256(c)
+		return tcell.PaletteColor(n)
+	case "16":
+		n, _ := nearest16(c)
+		return tcell.PaletteColor(n)
+	}
+	return tcell.NewRGBColor(int32(c.R), int32(c.G), int32(c.B))
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// slideshowInterval moves the viewer to the next picture on its own, 0 waits
+// for a key
+var slideshowInterval time.Duration
+
+// defaultSlideshow is used when the slideshow is started with the spacebar
+// and -slideshow wasn't given
+const defaultSlideshow = 5 * time.Second
+
+// imageSort orders the pictures of the viewer, by name or date
+var imageSort = "name"
+
+func validImageSort(s string) bool {
+	return s == "name" || s == "date"
+}
+
+// isImageFile reports whether name starts like a picture
+func isImageFile(name string) bool {
+	f, err := os.Open(name)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	head := make([]byte, 16)
+	n, _ := f.Read(head)
+	return isImage(head[:n])
+}
+
+// imageFiles lists the pictures in dir, sub directories are left out
+func imageFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		name := filepath.Join(dir, entry.Name())
+		if entry.Type().IsRegular() && isImageFile(name) {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// sortImages sorts names by imageSort, by date puts the oldest first
+func sortImages(names []string) {
+	if imageSort == "date" {
+		times := make(map[string]time.Time, len(names))
+		for _, name := range names {
+			if info, err := os.Stat(name); err == nil {
+				times[name] = info.ModTime()
+			}
+		}
+		sort.SliceStable(names, func(a, b int) bool {
+			return times[names[a]].Before(times[names[b]])
+		})
+		return
+	}
+	sort.Strings(names)
+}
+
+// imageInfo is the first status line of the viewer
+func imageInfo(name string, data []byte, index, count int) string {
+	info := filepath.Base(name)
+	if config, format, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+		info += fmt.Sprintf("  %dx%d %s", config.Width, config.Height, strings.ToUpper(format))
+	} else {
+		info += "  " + err.Error()
+	}
+	if count > 1 {
+		info += fmt.Sprintf("  %d/%d", index+1, count)
+	}
+	return info
+}
+
+// viewerStatus lays out the status lines of the image viewer, see statusText
+func viewerStatus(info string, slideshow time.Duration) string {
+	help := "'p' 'n'  | spacebar  | 'f' | 'o'  | '+' '-' | hjkl/arrows |  'z'  | 'm'  | b c g s 0 |  1-9   |  'q'"
+	keys := "prev next| slideshow | fit | sort |  zoom   |     pan     | reset | mode | tone ▼▲ ↺ | filter | quit"
+	state := ""
+	if slideshow > 0 {
+		state = "  slideshow " + slideshow.String()
+	}
+	return info + "\n" + state + zoomStatus() + toneStatus() + filterStatus() + "\n" + help + "\n" + keys
+}
+
+// viewImages shows pictures until q is pressed. Unlike the plain image mode
+// they can be zoomed and panned, and take the same tone, filter and mode keys
+// as the player. n and p move between the pictures, the spacebar starts and
+// stops the slideshow.
+func viewImages(names []string) {
+	reservedRows = statusLines
+	app := tview.NewApplication()
+	view := newCellView()
+	status := newStatusView()
+	layout := playerLayout(view, status)
+
+	var index int
+	var data []byte
+	var info string
+	var pendingGraphics []byte
+	slideshow := slideshowInterval
+	var timer *time.Timer
+	// shown counts the calls to show, a tick that was queued before the
+	// picture changed is ignored
+	var shown int
+
+	render := func() {
+		if _, _, err := image.DecodeConfig(bytes.NewReader(data)); err != nil {
+			view.SetFrame(nil)
+		} else if outputMode != "ansi" {
+			pendingGraphics = placeGraphics(renderGraphics(data))
+		} else {
+			frame := renderFrame(data)
+			view.SetFrame(frame)
+			layout.ResizeItem(view, frame.Height, 0)
+		}
+		status.SetText(viewerStatus(info, slideshow))
+	}
+
+	// show loads picture n, counted from 0 and wrapping around at both ends.
+	// Like render it runs on the application's goroutine.
+	var show func(n int)
+	show = func(n int) {
+		index = (n%len(names) + len(names)) % len(names)
+		var err error
+		if data, err = os.ReadFile(names[index]); err != nil {
+			data = nil
+		}
+		info = imageInfo(names[index], data, index, len(names))
+		zoomLevel, zoomX, zoomY = 1, 0.5, 0.5
+		render()
+
+		shown++
+		if timer != nil {
+			timer.Stop()
+		}
+		if slideshow > 0 && len(names) > 1 {
+			armed := shown
+			timer = time.AfterFunc(slideshow, func() {
+				app.QueueUpdateDraw(func() {
+					if armed == shown {
+						show(index + 1)
+					}
+				})
+			})
+		}
+	}
+
+	app.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if event.Rune() == 'q' || event.Key() == tcell.KeyEscape {
+			if outputMode == "kitty" {
+				os.Stdout.Write(kitty.Clear())
+			}
+			app.Stop()
+			return nil
+		}
+		switch {
+		case event.Rune() == 'n' || event.Key() == tcell.KeyPgDn:
+			show(index + 1)
+			return nil
+		case event.Rune() == 'p' || event.Key() == tcell.KeyPgUp:
+			show(index - 1)
+			return nil
+		case event.Rune() == ' ':
+			if slideshow > 0 {
+				slideshow = 0
+			} else if slideshow = slideshowInterval; slideshow == 0 {
+				slideshow = defaultSlideshow
+			}
+			// starts the clock again, or stops it
+			show(index)
+			return nil
+		case event.Rune() == 'o':
+			// the picture on screen stays, only its neighbours change
+			current := names[index]
+			if imageSort == "name" {
+				imageSort = "date"
+			} else {
+				imageSort = "name"
+			}
+			sortImages(names)
+			for n, name := range names {
+				if name == current {
+					index = n
+				}
+			}
+			info = imageInfo(current, data, index, len(names)) + "  by " + imageSort
+			status.SetText(viewerStatus(info, slideshow))
+			return nil
+		}
+
+		changed := zoomKey(event) || toneKey(event.Rune()) || toggleFilter(event.Rune())
+		switch event.Rune() {
+		case 'm':
+			cellModeIndex = (cellModeIndex + 1) % len(cellModes)
+			changed = true
+		case 'f':
+			fitScreen = !fitScreen
+			kitty.Reset()
+			changed = true
+		}
+		if changed {
+			render()
+		}
+		return nil
+	})
+	app.SetBeforeDrawFunc(func(screen tcell.Screen) bool {
+		cols, rows := screen.Size()
+		if cols != screenCols || rows != screenRows {
+			screenCols, screenRows = cols, rows
+			kitty.Reset()
+			if data == nil && info == "" {
+				// the first draw, the screen size is known now
+				show(0)
+			} else {
+				render()
+			}
+		}
+		return false
+	})
+	app.SetAfterDrawFunc(func(screen tcell.Screen) {
+		if pendingGraphics != nil {
+			os.Stdout.Write(pendingGraphics)
+			pendingGraphics = nil
+		}
+	})
+
+	if err := app.SetRoot(layout, true).Run(); err != nil {
+		panic(err)
+	}
+}
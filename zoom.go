@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+
+	"github.com/gdamore/tcell/v2"
+)
+
+// zoomLevel magnifies a part of the picture, 1 shows all of it. zoomX and
+// zoomY are the centre of the visible part as a fraction of the width and
+// height of the picture.
+var zoomLevel = 1.0
+var zoomX, zoomY = 0.5, 0.5
+
+const maxZoom = 16.0
+
+// zoomStep is the factor one key press zooms in or out by
+const zoomStep = 1.25
+
+// panStep is how far one key press moves, a fraction of the visible part
+const panStep = 0.1
+
+// zoomKey zooms with + and -, pans with hjkl or the arrow keys and resets
+// with z. It reports whether the key was one of them.
+func zoomKey(event *tcell.EventKey) bool {
+	switch event.Key() {
+	case tcell.KeyLeft:
+		zoomX -= panStep / zoomLevel
+	case tcell.KeyRight:
+		zoomX += panStep / zoomLevel
+	case tcell.KeyUp:
+		zoomY -= panStep / zoomLevel
+	case tcell.KeyDown:
+		zoomY += panStep / zoomLevel
+	case tcell.KeyRune:
+		switch event.Rune() {
+		case '+', '=':
+			zoomLevel *= zoomStep
+		case '-':
+			zoomLevel /= zoomStep
+		case 'h':
+			zoomX -= panStep / zoomLevel
+		case 'l':
+			zoomX += panStep / zoomLevel
+		case 'k':
+			zoomY -= panStep / zoomLevel
+		case 'j':
+			zoomY += panStep / zoomLevel
+		case 'z':
+			zoomLevel, zoomX, zoomY = 1, 0.5, 0.5
+		default:
+			return false
+		}
+	default:
+		return false
+	}
+	clampZoom()
+	return true
+}
+
+// clampZoom keeps the visible part inside the picture
+func clampZoom() {
+	zoomLevel = math.Max(1, math.Min(maxZoom, zoomLevel))
+	half := 0.5 / zoomLevel
+	zoomX = math.Max(half, math.Min(1-half, zoomX))
+	zoomY = math.Max(half, math.Min(1-half, zoomY))
+}
+
+// zoomStatus shows the zoom in the status lines, empty when the whole
+// picture is visible
+func zoomStatus() string {
+	if zoomLevel <= 1 {
+		return ""
+	}
+	return fmt.Sprintf("  zoom %.1fx", zoomLevel)
+}
+
+// zoomCrop returns the visible part of img. It keeps the aspect ratio of the
+// whole picture, so it can be scaled to the size the whole picture would have
+// had and shows more detail.
+func zoomCrop(img image.Image) image.Image {
+	if zoomLevel <= 1 {
+		return img
+	}
+	b := img.Bounds()
+	w := int(math.Max(1, math.Round(float64(b.Dx())/zoomLevel)))
+	h := int(math.Max(1, math.Round(float64(b.Dy())/zoomLevel)))
+	x := b.Min.X + int(math.Round(zoomX*float64(b.Dx()))) - w/2
+	y := b.Min.Y + int(math.Round(zoomY*float64(b.Dy()))) - h/2
+	if x < b.Min.X {
+		x = b.Min.X
+	}
+	if x > b.Max.X-w {
+		x = b.Max.X - w
+	}
+	if y < b.Min.Y {
+		y = b.Min.Y
+	}
+	if y > b.Max.Y-h {
+		y = b.Max.Y - h
+	}
+	crop := image.Rect(x, y, x+w, y+h)
+	if sub, ok := img.(interface {
+		SubImage(image.Rectangle) image.Image
+	}); ok {
+		return sub.SubImage(crop)
+	}
+	rgba := toRGBA(img)
+	return rgba.SubImage(crop.Sub(b.Min))
+}